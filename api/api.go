@@ -0,0 +1,205 @@
+// Package api defines the versioned response schema of simtask, so that
+// clients can decode responses without depending on the function itself.
+// Timings are integers: Unix ns for points in time and ns for durations.
+package api
+
+import (
+	_ "embed"
+
+	"function/workload"
+)
+
+// Version is the version of the response schema defined by this package.
+const Version = 2
+
+// Schema is the JSON Schema of Response, served by the function on /schema.
+//
+//go:embed response.schema.json
+var Schema []byte
+
+// Response is the result of a simulation request.
+type Response struct {
+	Version    int                     `json:"version"`
+	Client     string                  `json:"cl"`
+	ID         string                  `json:"id,omitempty"`
+	T0         string                  `json:"t0,omitempty"`
+	Experiment string                  `json:"exp,omitempty"`
+	Timing     Timing                  `json:"timing"`
+	Stages     []Stage                 `json:"stages"`
+	Tail       *workload.TailInjection `json:"tail,omitempty"`
+	Fault      *Fault                  `json:"fault,omitempty"`
+	TraceID    string                  `json:"trace_id,omitempty"`
+	SpanID     string                  `json:"span_id,omitempty"`
+	Instance   Instance                `json:"instance"`
+	Metrics    Metrics                 `json:"metrics"`
+	Custom     *Custom                 `json:"custom,omitempty"`
+}
+
+// Timing is the real timeline of the request: when the function started
+// and finished processing it, and the time spent in each kind of stage.
+// IdleOvershoot is the idle time beyond its target.
+type Timing struct {
+	Start         int64 `json:"start"`
+	End           int64 `json:"end"`
+	Total         int64 `json:"total"`
+	Idle          int64 `json:"idle"`
+	Busy          int64 `json:"busy"`
+	Call          int64 `json:"call"`
+	IdleOvershoot int64 `json:"idle_overshoot"`
+}
+
+// Targets are the targets of a stage: a duration, which is the wall time of
+// busy stages, a number of iterations and a thread CPU time.
+type Targets struct {
+	Duration   int64 `json:"duration"`
+	Iterations int64 `json:"iterations"`
+	CPU        int64 `json:"cpu"`
+}
+
+// Stage is what a stage of the task did. Sampled are its targets as drawn
+// and Target the ones it ran with, after the tail injection. Method is set
+// for idle stages; Kernel, Iterations, CPU, End and Speed, the calibrated
+// speed of the kernel on this node, for busy stages; Calls for call stages.
+type Stage struct {
+	Kind       string                `json:"kind"`
+	Start      int64                 `json:"start"`
+	Duration   int64                 `json:"duration"`
+	Sampled    Targets               `json:"sampled"`
+	Target     Targets               `json:"target"`
+	Method     string                `json:"method,omitempty"`
+	Kernel     string                `json:"kernel,omitempty"`
+	Iterations int64                 `json:"iterations,omitempty"`
+	CPU        int64                 `json:"cpu,omitempty"`
+	End        string                `json:"end,omitempty"`
+	Speed      float64               `json:"speed,omitempty"`
+	Calls      []workload.CallResult `json:"calls,omitempty"`
+}
+
+// Custom is the client metadata echoed from the request: the parameters
+// the function does not know and the headers it is configured to echo.
+// Repeated values are joined with commas.
+type Custom struct {
+	Params  map[string]string `json:"params,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Fault is the fault injected into the response.
+type Fault struct {
+	Mode string `json:"mode"`
+	Code int    `json:"code"`
+}
+
+// Instance is the function instance that served the request. Cold is set
+// on the first simulation request of the instance.
+type Instance struct {
+	ID       string `json:"id"`
+	Version  string `json:"version"`
+	Revision string `json:"revision,omitempty"`
+	Cold     bool   `json:"cold"`
+}
+
+// Metrics is the state of the node and of the function process once the
+// task finished. Metrics that cannot be read are zero.
+type Metrics struct {
+	Host    HostMetrics    `json:"host"`
+	Process ProcessMetrics `json:"process"`
+}
+
+// HostMetrics are node-wide. Memory sizes are in bytes and disk counters
+// are totals since boot.
+type HostMetrics struct {
+	CPUPercent   []float64 `json:"cpu_percent"`
+	Load1        float64   `json:"load1"`
+	Load5        float64   `json:"load5"`
+	Load15       float64   `json:"load15"`
+	MemTotal     uint64    `json:"mem_total"`
+	MemAvailable uint64    `json:"mem_available"`
+	MemUsed      uint64    `json:"mem_used"`
+	DiskReads    uint64    `json:"disk_reads"`
+	DiskWrites   uint64    `json:"disk_writes"`
+	DiskRead     uint64    `json:"disk_read_bytes"`
+	DiskWritten  uint64    `json:"disk_written_bytes"`
+}
+
+// ProcessMetrics are those of the function process. CPU times are in ns
+// and memory sizes in bytes.
+type ProcessMetrics struct {
+	Start               int64   `json:"start"`
+	CPUUser             int64   `json:"cpu_user"`
+	CPUSystem           int64   `json:"cpu_system"`
+	CPUPercent          float64 `json:"cpu_percent"`
+	RSS                 uint64  `json:"rss"`
+	VMS                 uint64  `json:"vms"`
+	MemPercent          float64 `json:"mem_percent"`
+	VoluntarySwitches   int64   `json:"voluntary_switches"`
+	InvoluntarySwitches int64   `json:"involuntary_switches"`
+	FDs                 int     `json:"fds"`
+	Threads             int     `json:"threads"`
+	Connections         int     `json:"connections"`
+	ReadBytes           uint64  `json:"read_bytes"`
+	WriteBytes          uint64  `json:"write_bytes"`
+}
+
+// Statuses of a Task.
+const (
+	TaskPending = "pending"
+	TaskRunning = "running"
+	TaskDone    = "done"
+	TaskFailed  = "failed"
+)
+
+// Task is a simulation request submitted asynchronously, served on
+// /tasks/{id} and posted to its callback. Submitted and Finished are Unix
+// ns; Result is set once the task is done and Error once it failed.
+type Task struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Submitted int64     `json:"submitted"`
+	Finished  int64     `json:"finished,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Callback  *Callback `json:"callback,omitempty"`
+	Result    *Response `json:"result,omitempty"`
+}
+
+// Callback is the delivery of a finished task to its callback URL: the
+// status the URL answered, or the error that prevented the delivery.
+type Callback struct {
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Batch is the result of a batch request: the result of each of its tasks,
+// in order, run sequentially or with up to Fanout at a time in parallel.
+type Batch struct {
+	Version int           `json:"version"`
+	Client  string        `json:"cl"`
+	ID      string        `json:"id,omitempty"`
+	Mode    string        `json:"mode"`
+	Fanout  int           `json:"fanout,omitempty"`
+	Timing  BatchTiming   `json:"timing"`
+	Results []BatchResult `json:"results"`
+}
+
+// BatchTiming is the aggregate timeline of a batch. Total is its makespan
+// and Tasks the sum of the totals of its tasks, above Total when tasks run
+// in parallel; Max is the longest task, and Idle, Busy and Call sum the
+// stages of all tasks.
+type BatchTiming struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Total int64 `json:"total"`
+	Tasks int64 `json:"tasks"`
+	Max   int64 `json:"max"`
+	Idle  int64 `json:"idle"`
+	Busy  int64 `json:"busy"`
+	Call  int64 `json:"call"`
+}
+
+// BatchResult is the outcome of a task of a batch: the status it would have
+// answered on its own, with its Response, or the Error that stopped it.
+type BatchResult struct {
+	Status   int       `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Response *Response `json:"response,omitempty"`
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"strconv"
+
+	"function/workload"
+)
+
+// csvColumns are the columns of the CSV form of a response: its identifiers,
+// timings and the targets and outcome of its first idle and busy stages.
+var csvColumns = []struct {
+	name  string
+	value func(r Response, idle, busy Stage) string
+}{
+	{"cl", func(r Response, _, _ Stage) string { return r.Client }},
+	{"id", func(r Response, _, _ Stage) string { return r.ID }},
+	{"t0", func(r Response, _, _ Stage) string { return r.T0 }},
+	{"exp", func(r Response, _, _ Stage) string { return r.Experiment }},
+	{"instance", func(r Response, _, _ Stage) string { return r.Instance.ID }},
+	{"cold", func(r Response, _, _ Stage) string { return strconv.FormatBool(r.Instance.Cold) }},
+	{"start", func(r Response, _, _ Stage) string { return itoa(r.Timing.Start) }},
+	{"end", func(r Response, _, _ Stage) string { return itoa(r.Timing.End) }},
+	{"total", func(r Response, _, _ Stage) string { return itoa(r.Timing.Total) }},
+	{"idle", func(r Response, _, _ Stage) string { return itoa(r.Timing.Idle) }},
+	{"busy", func(r Response, _, _ Stage) string { return itoa(r.Timing.Busy) }},
+	{"call", func(r Response, _, _ Stage) string { return itoa(r.Timing.Call) }},
+	{"idle_overshoot", func(r Response, _, _ Stage) string { return itoa(r.Timing.IdleOvershoot) }},
+	{"sampled_idle", func(_ Response, idle, _ Stage) string { return itoa(idle.Sampled.Duration) }},
+	{"sampled_busy", func(_ Response, _, busy Stage) string { return itoa(busy.Sampled.Duration) }},
+	{"sampled_iterations", func(_ Response, _, busy Stage) string { return itoa(busy.Sampled.Iterations) }},
+	{"sampled_cpu", func(_ Response, _, busy Stage) string { return itoa(busy.Sampled.CPU) }},
+	{"iterations", func(_ Response, _, busy Stage) string { return itoa(busy.Iterations) }},
+	{"cpu", func(_ Response, _, busy Stage) string { return itoa(busy.CPU) }},
+	{"busy_end", func(_ Response, _, busy Stage) string { return busy.End }},
+	{"method", func(_ Response, idle, _ Stage) string { return idle.Method }},
+	{"kernel", func(_ Response, _, busy Stage) string { return busy.Kernel }},
+	{"speed", func(_ Response, _, busy Stage) string { return strconv.FormatFloat(busy.Speed, 'g', -1, 64) }},
+	{"tail_extra", func(r Response, _, _ Stage) string {
+		if r.Tail == nil {
+			return ""
+		}
+		return itoa(r.Tail.Extra)
+	}},
+	{"fault", func(r Response, _, _ Stage) string {
+		if r.Fault == nil {
+			return ""
+		}
+		return r.Fault.Mode
+	}},
+	{"trace_id", func(r Response, _, _ Stage) string { return r.TraceID }},
+}
+
+// CSVHeader returns the column names of CSVRecord.
+func CSVHeader() []string {
+	h := make([]string, len(csvColumns))
+	for i, c := range csvColumns {
+		h[i] = c.name
+	}
+	return h
+}
+
+// CSVRecord flattens the identifiers and timings of r into one row. Stage
+// columns describe the first idle and busy stages; metrics and call results
+// are left out.
+func (r Response) CSVRecord() []string {
+	var idle, busy Stage
+	for i := len(r.Stages) - 1; i >= 0; i-- {
+		switch s := r.Stages[i]; s.Kind {
+		case workload.StageIdle:
+			idle = s
+		case workload.StageBusy:
+			busy = s
+		}
+	}
+	rec := make([]string, len(csvColumns))
+	for i, c := range csvColumns {
+		rec[i] = c.value(r, idle, busy)
+	}
+	return rec
+}
+
+func itoa(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
@@ -0,0 +1,504 @@
+package api
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"function/workload"
+)
+
+// Proto is the Protobuf definition of Response, served by the function on
+// /schema.proto. MarshalProto and UnmarshalProto implement it without
+// generated code.
+//
+//go:embed simtask.proto
+var Proto []byte
+
+// MarshalProto encodes r as a simtask.v2.Response message.
+func (r Response) MarshalProto() []byte {
+	var e protoEncoder
+	e.int(1, int64(r.Version))
+	e.string(2, r.Client)
+	e.string(3, r.ID)
+	e.string(4, r.T0)
+	e.string(5, r.Experiment)
+	e.message(6, func(e *protoEncoder) {
+		t := r.Timing
+		e.int(1, t.Start)
+		e.int(2, t.End)
+		e.int(3, t.Total)
+		e.int(4, t.Idle)
+		e.int(5, t.Busy)
+		e.int(6, t.Call)
+		e.int(7, t.IdleOvershoot)
+	})
+	for _, s := range r.Stages {
+		e.message(7, s.marshalProto)
+	}
+	if t := r.Tail; t != nil {
+		e.message(8, func(e *protoEncoder) {
+			e.string(1, t.Stage)
+			e.double(2, t.Factor)
+			e.int(3, t.Extra)
+		})
+	}
+	if f := r.Fault; f != nil {
+		e.message(9, func(e *protoEncoder) {
+			e.string(1, f.Mode)
+			e.int(2, int64(f.Code))
+		})
+	}
+	e.string(10, r.TraceID)
+	e.string(11, r.SpanID)
+	e.message(12, func(e *protoEncoder) {
+		i := r.Instance
+		e.string(1, i.ID)
+		e.string(2, i.Version)
+		e.string(3, i.Revision)
+		e.bool(4, i.Cold)
+	})
+	e.message(13, func(e *protoEncoder) {
+		e.message(1, r.Metrics.Host.marshalProto)
+		e.message(2, r.Metrics.Process.marshalProto)
+	})
+	if c := r.Custom; c != nil {
+		e.message(14, func(e *protoEncoder) {
+			e.stringMap(1, c.Params)
+			e.stringMap(2, c.Headers)
+		})
+	}
+	return e.b
+}
+
+func (s Stage) marshalProto(e *protoEncoder) {
+	e.string(1, s.Kind)
+	e.int(2, s.Start)
+	e.int(3, s.Duration)
+	e.message(4, s.Sampled.marshalProto)
+	e.message(5, s.Target.marshalProto)
+	e.string(6, s.Method)
+	e.string(7, s.Kernel)
+	e.int(8, s.Iterations)
+	e.int(9, s.CPU)
+	e.string(10, s.End)
+	e.double(11, s.Speed)
+	for _, c := range s.Calls {
+		e.message(12, func(e *protoEncoder) {
+			e.string(1, c.URL)
+			e.string(2, c.Host)
+			e.int(3, int64(c.Status))
+			e.int(4, c.Start)
+			e.int(5, c.Latency)
+			e.bytes(6, c.Body)
+			e.string(7, c.Error)
+		})
+	}
+}
+
+func (t Targets) marshalProto(e *protoEncoder) {
+	e.int(1, t.Duration)
+	e.int(2, t.Iterations)
+	e.int(3, t.CPU)
+}
+
+func (h HostMetrics) marshalProto(e *protoEncoder) {
+	if len(h.CPUPercent) > 0 {
+		var packed []byte
+		for _, v := range h.CPUPercent {
+			packed = protowire.AppendFixed64(packed, math.Float64bits(v))
+		}
+		e.bytes(1, packed)
+	}
+	e.double(2, h.Load1)
+	e.double(3, h.Load5)
+	e.double(4, h.Load15)
+	e.uint(5, h.MemTotal)
+	e.uint(6, h.MemAvailable)
+	e.uint(7, h.MemUsed)
+	e.uint(8, h.DiskReads)
+	e.uint(9, h.DiskWrites)
+	e.uint(10, h.DiskRead)
+	e.uint(11, h.DiskWritten)
+}
+
+func (p ProcessMetrics) marshalProto(e *protoEncoder) {
+	e.int(1, p.Start)
+	e.int(2, p.CPUUser)
+	e.int(3, p.CPUSystem)
+	e.double(4, p.CPUPercent)
+	e.uint(5, p.RSS)
+	e.uint(6, p.VMS)
+	e.double(7, p.MemPercent)
+	e.int(8, p.VoluntarySwitches)
+	e.int(9, p.InvoluntarySwitches)
+	e.int(10, int64(p.FDs))
+	e.int(11, int64(p.Threads))
+	e.int(12, int64(p.Connections))
+	e.uint(13, p.ReadBytes)
+	e.uint(14, p.WriteBytes)
+}
+
+// UnmarshalProto decodes a simtask.v2.Response message into r. Unknown
+// fields are skipped.
+func (r *Response) UnmarshalProto(b []byte) error {
+	*r = Response{}
+	return decodeProto(b, func(num protowire.Number, f protoField) error {
+		switch num {
+		case 1:
+			r.Version = int(f.int())
+		case 2:
+			r.Client = f.string()
+		case 3:
+			r.ID = f.string()
+		case 4:
+			r.T0 = f.string()
+		case 5:
+			r.Experiment = f.string()
+		case 6:
+			t := &r.Timing
+			return decodeProto(f.b, func(num protowire.Number, f protoField) error {
+				if dst := []*int64{&t.Start, &t.End, &t.Total, &t.Idle, &t.Busy, &t.Call, &t.IdleOvershoot}; int(num) <= len(dst) {
+					*dst[num-1] = f.int()
+				}
+				return nil
+			})
+		case 7:
+			var s Stage
+			if err := s.unmarshalProto(f.b); err != nil {
+				return err
+			}
+			r.Stages = append(r.Stages, s)
+		case 8:
+			r.Tail = &workload.TailInjection{}
+			return decodeProto(f.b, func(num protowire.Number, f protoField) error {
+				switch num {
+				case 1:
+					r.Tail.Stage = f.string()
+				case 2:
+					r.Tail.Factor = f.double()
+				case 3:
+					r.Tail.Extra = f.int()
+				}
+				return nil
+			})
+		case 9:
+			r.Fault = &Fault{}
+			return decodeProto(f.b, func(num protowire.Number, f protoField) error {
+				switch num {
+				case 1:
+					r.Fault.Mode = f.string()
+				case 2:
+					r.Fault.Code = int(f.int())
+				}
+				return nil
+			})
+		case 10:
+			r.TraceID = f.string()
+		case 11:
+			r.SpanID = f.string()
+		case 12:
+			i := &r.Instance
+			return decodeProto(f.b, func(num protowire.Number, f protoField) error {
+				switch num {
+				case 1:
+					i.ID = f.string()
+				case 2:
+					i.Version = f.string()
+				case 3:
+					i.Revision = f.string()
+				case 4:
+					i.Cold = f.x != 0
+				}
+				return nil
+			})
+		case 13:
+			return decodeProto(f.b, func(num protowire.Number, f protoField) error {
+				switch num {
+				case 1:
+					return r.Metrics.Host.unmarshalProto(f.b)
+				case 2:
+					return r.Metrics.Process.unmarshalProto(f.b)
+				}
+				return nil
+			})
+		case 14:
+			r.Custom = &Custom{}
+			return decodeProto(f.b, func(num protowire.Number, f protoField) error {
+				switch num {
+				case 1:
+					return decodeStringMap(f.b, &r.Custom.Params)
+				case 2:
+					return decodeStringMap(f.b, &r.Custom.Headers)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+func (s *Stage) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(num protowire.Number, f protoField) error {
+		switch num {
+		case 1:
+			s.Kind = f.string()
+		case 2:
+			s.Start = f.int()
+		case 3:
+			s.Duration = f.int()
+		case 4:
+			return s.Sampled.unmarshalProto(f.b)
+		case 5:
+			return s.Target.unmarshalProto(f.b)
+		case 6:
+			s.Method = f.string()
+		case 7:
+			s.Kernel = f.string()
+		case 8:
+			s.Iterations = f.int()
+		case 9:
+			s.CPU = f.int()
+		case 10:
+			s.End = f.string()
+		case 11:
+			s.Speed = f.double()
+		case 12:
+			var c workload.CallResult
+			err := decodeProto(f.b, func(num protowire.Number, f protoField) error {
+				switch num {
+				case 1:
+					c.URL = f.string()
+				case 2:
+					c.Host = f.string()
+				case 3:
+					c.Status = int(f.int())
+				case 4:
+					c.Start = f.int()
+				case 5:
+					c.Latency = f.int()
+				case 6:
+					c.Body = append([]byte(nil), f.b...)
+				case 7:
+					c.Error = f.string()
+				}
+				return nil
+			})
+			s.Calls = append(s.Calls, c)
+			return err
+		}
+		return nil
+	})
+}
+
+func (t *Targets) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(num protowire.Number, f protoField) error {
+		if dst := []*int64{&t.Duration, &t.Iterations, &t.CPU}; int(num) <= len(dst) {
+			*dst[num-1] = f.int()
+		}
+		return nil
+	})
+}
+
+func (h *HostMetrics) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(num protowire.Number, f protoField) error {
+		switch num {
+		case 1:
+			if f.typ == protowire.Fixed64Type {
+				h.CPUPercent = append(h.CPUPercent, f.double())
+				return nil
+			}
+			for p := f.b; len(p) > 0; {
+				v, n := protowire.ConsumeFixed64(p)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				h.CPUPercent = append(h.CPUPercent, math.Float64frombits(v))
+				p = p[n:]
+			}
+		case 2:
+			h.Load1 = f.double()
+		case 3:
+			h.Load5 = f.double()
+		case 4:
+			h.Load15 = f.double()
+		default:
+			if dst := []*uint64{&h.MemTotal, &h.MemAvailable, &h.MemUsed, &h.DiskReads, &h.DiskWrites, &h.DiskRead, &h.DiskWritten}; num >= 5 && int(num) < 5+len(dst) {
+				*dst[num-5] = f.x
+			}
+		}
+		return nil
+	})
+}
+
+func (p *ProcessMetrics) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(num protowire.Number, f protoField) error {
+		switch num {
+		case 1:
+			p.Start = f.int()
+		case 2:
+			p.CPUUser = f.int()
+		case 3:
+			p.CPUSystem = f.int()
+		case 4:
+			p.CPUPercent = f.double()
+		case 5:
+			p.RSS = f.x
+		case 6:
+			p.VMS = f.x
+		case 7:
+			p.MemPercent = f.double()
+		case 8:
+			p.VoluntarySwitches = f.int()
+		case 9:
+			p.InvoluntarySwitches = f.int()
+		case 10:
+			p.FDs = int(f.int())
+		case 11:
+			p.Threads = int(f.int())
+		case 12:
+			p.Connections = int(f.int())
+		case 13:
+			p.ReadBytes = f.x
+		case 14:
+			p.WriteBytes = f.x
+		}
+		return nil
+	})
+}
+
+// protoEncoder appends fields to a message, leaving out zero scalars as
+// proto3 does.
+type protoEncoder struct {
+	b []byte
+}
+
+func (e *protoEncoder) int(num protowire.Number, v int64) {
+	e.uint(num, uint64(v))
+}
+
+func (e *protoEncoder) uint(num protowire.Number, v uint64) {
+	if v != 0 {
+		e.b = protowire.AppendTag(e.b, num, protowire.VarintType)
+		e.b = protowire.AppendVarint(e.b, v)
+	}
+}
+
+func (e *protoEncoder) bool(num protowire.Number, v bool) {
+	if v {
+		e.uint(num, 1)
+	}
+}
+
+func (e *protoEncoder) double(num protowire.Number, v float64) {
+	if v != 0 {
+		e.b = protowire.AppendTag(e.b, num, protowire.Fixed64Type)
+		e.b = protowire.AppendFixed64(e.b, math.Float64bits(v))
+	}
+}
+
+func (e *protoEncoder) string(num protowire.Number, v string) {
+	if v != "" {
+		e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+		e.b = protowire.AppendString(e.b, v)
+	}
+}
+
+func (e *protoEncoder) bytes(num protowire.Number, v []byte) {
+	if len(v) > 0 {
+		e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+		e.b = protowire.AppendBytes(e.b, v)
+	}
+}
+
+// stringMap appends the entries of a map<string, string> field, sorted by
+// key.
+func (e *protoEncoder) stringMap(num protowire.Number, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.message(num, func(e *protoEncoder) {
+			e.string(1, k)
+			e.string(2, m[k])
+		})
+	}
+}
+
+// message appends the sub-message written by fn.
+func (e *protoEncoder) message(num protowire.Number, fn func(e *protoEncoder)) {
+	var sub protoEncoder
+	fn(&sub)
+	e.b = protowire.AppendTag(e.b, num, protowire.BytesType)
+	e.b = protowire.AppendBytes(e.b, sub.b)
+}
+
+// protoField is a decoded field value: x holds varint and fixed values, b
+// length-delimited ones.
+type protoField struct {
+	typ protowire.Type
+	x   uint64
+	b   []byte
+}
+
+func (f protoField) int() int64      { return int64(f.x) }
+func (f protoField) double() float64 { return math.Float64frombits(f.x) }
+func (f protoField) string() string  { return string(f.b) }
+
+// decodeStringMap adds the map entry in b to *m.
+func decodeStringMap(b []byte, m *map[string]string) error {
+	var k, v string
+	err := decodeProto(b, func(num protowire.Number, f protoField) error {
+		switch num {
+		case 1:
+			k = f.string()
+		case 2:
+			v = f.string()
+		}
+		return nil
+	})
+	if *m == nil {
+		*m = map[string]string{}
+	}
+	(*m)[k] = v
+	return err
+}
+
+// decodeProto calls field for every field of the message in b.
+func decodeProto(b []byte, field func(num protowire.Number, f protoField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		f := protoField{typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.x, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.x, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.x = uint64(v)
+		case protowire.BytesType:
+			f.b, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if err := field(num, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"function/workload"
+)
+
+// TestProtoRoundTrip ensures that UnmarshalProto decodes what MarshalProto
+// encodes.
+func TestProtoRoundTrip(t *testing.T) {
+	want := Response{
+		Version: Version, Client: "1", ID: "r", T0: "5", Experiment: "e",
+		Timing: Timing{Start: 1e18, End: 1e18 + 900, Total: 900, Idle: 300, Busy: 500, Call: 50, IdleOvershoot: -2},
+		Stages: []Stage{
+			{Kind: workload.StageIdle, Start: 1e18, Duration: 300, Sampled: Targets{Duration: 302}, Target: Targets{Duration: 302}, Method: "sleep"},
+			{Kind: workload.StageBusy, Start: 1e18 + 300, Duration: 500, Target: Targets{Iterations: 10, CPU: 400}, Kernel: "hash", Iterations: 10, CPU: 450, End: "iterations", Speed: 1.25},
+			{Kind: workload.StageCall, Calls: []workload.CallResult{{URL: "http://a", Status: 200, Start: 1, Latency: 40, Body: json.RawMessage(`{"version":2}`)}}},
+		},
+		Tail:     &workload.TailInjection{Stage: "busy", Factor: 2, Extra: 7},
+		Fault:    &Fault{Mode: "status", Code: 503},
+		TraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+		Custom:   &Custom{Params: map[string]string{"run": "3", "arm": "b"}, Headers: map[string]string{"X-Run": "3"}},
+		Instance: Instance{ID: "i", Version: "0.1.1", Cold: true},
+		Metrics: Metrics{
+			Host:    HostMetrics{CPUPercent: []float64{12.5, 0, 100}, Load1: 0.5, MemTotal: 1 << 30, DiskWritten: 42},
+			Process: ProcessMetrics{Start: 1e18, CPUUser: 1e7, RSS: 1 << 20, FDs: 9, Threads: 4, WriteBytes: 3},
+		},
+	}
+	var got Response
+	if err := got.UnmarshalProto(want.MarshalProto()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("round trip differs:\n got %+v\nwant %+v", got, want)
+	}
+}
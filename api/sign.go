@@ -0,0 +1,59 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters of requests signed with SignQuery.
+const (
+	ParamExpires = "expires"
+	ParamSig     = "sig"
+)
+
+// SignQuery authenticates a request until expires: it sets the expires
+// parameter of query, in Unix seconds, and sig, the hex HMAC-SHA256 under
+// key of the method, path, the other parameters and the body of the
+// request, nil when it has none.
+func SignQuery(key []byte, method, path string, query url.Values, body []byte, expires time.Time) {
+	query.Del(ParamSig)
+	query.Set(ParamExpires, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(ParamSig, signature(key, method, path, query, body))
+}
+
+// VerifyQuery checks that query carries a signature of the request under
+// key that has not expired at now.
+func VerifyQuery(key []byte, method, path string, query url.Values, body []byte, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return errors.New("missing or bad expires parameter")
+	}
+	if now.Unix() > expires {
+		return errors.New("signature expired")
+	}
+	if !hmac.Equal([]byte(query.Get(ParamSig)), []byte(signature(key, method, path, query, body))) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// signature signs the method, path, the parameters of query but sig, in
+// their sorted and escaped form, and the hex SHA-256 of the body.
+func signature(key []byte, method, path string, query url.Values, body []byte) string {
+	q := url.Values{}
+	for k, v := range query {
+		if k != ParamSig {
+			q[k] = v
+		}
+	}
+	mac := hmac.New(sha256.New, key)
+	digest := sha256.Sum256(body)
+	_, _ = io.WriteString(mac, method+"\n"+path+"\n"+q.Encode()+"\n"+hex.EncodeToString(digest[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,329 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+
+	"function/api"
+)
+
+// parseAsync reads whether a request is submitted asynchronously: with the
+// async parameter, a Prefer: respond-async header or a callback URL in cb.
+func parseAsync(req *http.Request, params url.Values) (bool, string, error) {
+	async := strings.Contains(req.Header.Get("Prefer"), "respond-async")
+	if params.Has("async") {
+		v, err := strconv.ParseBool(params.Get("async"))
+		if err != nil {
+			return false, "", badParam("async", "must be a boolean")
+		}
+		async = v
+	}
+	cb := params.Get("cb")
+	if cb == "" {
+		return async, "", nil
+	}
+	u, err := url.Parse(cb)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false, "", badParam("cb", "must be an absolute http or https URL")
+	}
+	return true, cb, nil
+}
+
+// taskStore keeps the asynchronous tasks of the instance. Finished tasks
+// are retained for ttl, when positive, and the oldest finished ones are
+// evicted to keep at most max tasks; submissions are refused once max
+// tasks are unfinished.
+type taskStore struct {
+	mu    sync.Mutex
+	max   int
+	ttl   time.Duration
+	tasks map[string]*api.Task
+	ids   []string // in submission order
+	wg    sync.WaitGroup
+}
+
+// tasks is read from SIMTASK_ASYNC_MAX_TASKS (default 1000) and
+// SIMTASK_ASYNC_TTL, in ns or with a unit (default 10m).
+var tasks = taskStoreFromEnv()
+
+func taskStoreFromEnv() *taskStore {
+	s := &taskStore{max: 1000, ttl: 10 * time.Minute, tasks: map[string]*api.Task{}}
+	if v, ok := os.LookupEnv("SIMTASK_ASYNC_MAX_TASKS"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			s.max = n
+		} else {
+			logger.Warn("ignoring bad SIMTASK_ASYNC_MAX_TASKS", "value", v)
+		}
+	}
+	if v, ok := os.LookupEnv("SIMTASK_ASYNC_TTL"); ok {
+		if d, err := parseDuration(v); err == nil {
+			s.ttl = d
+		} else {
+			logger.Warn("ignoring bad SIMTASK_ASYNC_TTL", "value", v, "error", err)
+		}
+	}
+	return s
+}
+
+func finished(t *api.Task) bool {
+	return t.Status == api.TaskDone || t.Status == api.TaskFailed
+}
+
+// add stores a new task, unless the store is full of unfinished ones.
+func (s *taskStore) add(t *api.Task) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	for i := 0; len(s.ids) >= s.max && i < len(s.ids); {
+		if finished(s.tasks[s.ids[i]]) {
+			s.remove(i)
+		} else {
+			i++
+		}
+	}
+	if len(s.ids) >= s.max {
+		return false
+	}
+	s.tasks[t.ID] = t
+	s.ids = append(s.ids, t.ID)
+	asyncTasks.WithLabelValues(t.Status).Inc()
+	return true
+}
+
+// get returns a copy of a retained task.
+func (s *taskStore) get(id string) (api.Task, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now())
+	t, ok := s.tasks[id]
+	if !ok {
+		return api.Task{}, false
+	}
+	return snapshot(t), true
+}
+
+// update applies f to a retained task and returns a copy of the result.
+func (s *taskStore) update(id string, f func(*api.Task)) api.Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return api.Task{}
+	}
+	status := t.Status
+	f(t)
+	if t.Status != status {
+		asyncTasks.WithLabelValues(status).Dec()
+		asyncTasks.WithLabelValues(t.Status).Inc()
+	}
+	return snapshot(t)
+}
+
+// snapshot copies a task, so that it can be read while the store updates
+// its callback.
+func snapshot(t *api.Task) api.Task {
+	c := *t
+	if t.Callback != nil {
+		cb := *t.Callback
+		c.Callback = &cb
+	}
+	return c
+}
+
+// expire evicts the tasks that finished more than ttl before now.
+func (s *taskStore) expire(now time.Time) {
+	if s.ttl <= 0 {
+		return
+	}
+	for i := 0; i < len(s.ids); {
+		t := s.tasks[s.ids[i]]
+		if finished(t) && now.Sub(time.Unix(0, t.Finished)) > s.ttl {
+			s.remove(i)
+		} else {
+			i++
+		}
+	}
+}
+
+func (s *taskStore) remove(i int) {
+	asyncTasks.WithLabelValues(s.tasks[s.ids[i]].Status).Dec()
+	delete(s.tasks, s.ids[i])
+	s.ids = append(s.ids[:i], s.ids[i+1:]...)
+}
+
+// wait waits for the running tasks and their callbacks, or for ctx.
+func (s *taskStore) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for async tasks: %w", ctx.Err())
+	}
+}
+
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// submitTask answers an asynchronous request with 202 and the pending task,
+// and runs it in the background, past the end of the request. Faults and
+// shaping apply to the acknowledgement: a faulty submission is not run,
+// like a request dropped by an overloaded platform.
+func submitTask(ctx context.Context, resp http.ResponseWriter, req *http.Request, sim simulation, rt0 time.Time, cold bool, rlog *slog.Logger) {
+	t := &api.Task{ID: newID(), Status: api.TaskPending, Submitted: rt0.UnixNano()}
+	if sim.callback != "" {
+		t.Callback = &api.Callback{URL: sim.callback}
+	}
+	rlog = rlog.With("task", t.ID)
+	status, transport := 202, ""
+	if sim.fired {
+		rlog.Warn("injecting fault", "fault", sim.fault.Mode, "code", sim.fault.code())
+		faultsTotal.WithLabelValues(sim.fault.Mode).Inc()
+		if sim.fault.inject(req) {
+			rlog.Info("request", "status", 0, "error", req.Context().Err())
+			return
+		}
+		if sim.fault.Mode == FaultStatus {
+			status = sim.fault.code()
+		} else {
+			transport = sim.fault.Mode
+		}
+		t.Status, t.Error = api.TaskFailed, "injected fault: "+sim.fault.Mode
+	} else if !tasks.add(t) {
+		resp.Header().Set("Retry-After", "1")
+		problemError(resp, req, fmt.Sprintf("%d unfinished tasks", tasks.max), 503, nil)
+		return
+	}
+	ack, err := json.Marshal(t)
+	if err != nil {
+		httpError(resp, req, err.Error(), 500)
+		return
+	}
+	if !sim.fired {
+		tasks.wg.Add(1)
+		go func() {
+			defer tasks.wg.Done()
+			tasks.run(context.WithoutCancel(ctx), t.ID, sim, rt0, cold, rlog)
+		}()
+		resp.Header().Add("Location", "/tasks/"+t.ID)
+	}
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Header().Add("X-Request-ID", req.URL.Query().Get("id"))
+	resp.Header().Add("Version", Version)
+	if err := sim.shaping.respond(req.Context(), resp, status, ack, transport); err != nil {
+		rlog.Info("request", "status", status, "error", err)
+		return
+	}
+	rlog.Info("request", "status", status)
+}
+
+// run executes a submitted task and delivers it to its callback.
+func (s *taskStore) run(ctx context.Context, id string, sim simulation, rt0 time.Time, cold bool, rlog *slog.Logger) {
+	ctx, span := tracer.Start(ctx, "simtask.task")
+	defer span.End()
+	s.update(id, func(t *api.Task) { t.Status = api.TaskRunning })
+	rlog.Debug("task started")
+	res, _, err := sim.run(ctx, rt0, cold)
+	t := s.update(id, func(t *api.Task) {
+		t.Finished = time.Now().UnixNano()
+		if err != nil {
+			t.Status, t.Error = api.TaskFailed, err.Error()
+			return
+		}
+		t.Status, t.Result = api.TaskDone, &res
+	})
+	if err != nil {
+		rlog.Warn("task failed", "error", err)
+	} else {
+		rlog.Info("task finished", timingGroup(res))
+	}
+	if t.Callback == nil {
+		return
+	}
+	status, err := deliver(ctx, t)
+	s.update(id, func(t *api.Task) {
+		t.Callback.Status = status
+		if err != nil {
+			t.Callback.Error = err.Error()
+		}
+	})
+	if err != nil {
+		rlog.Warn("callback failed", "url", t.Callback.URL, "status", status, "error", err)
+	}
+}
+
+// callbackClient posts finished tasks to their callbacks. Unlike
+// workload.Client, it never carries the credentials of the instance, as
+// clients choose the callback URLs; SIMTASK_CALL_TIMEOUT bounds it too.
+var callbackClient = &http.Client{Timeout: callTimeoutFromEnv()}
+
+// deliver posts a finished task to its callback URL and returns the status
+// it answered; statuses other than 2xx are errors.
+func deliver(ctx context.Context, t api.Task) (int, error) {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.Callback.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Task-ID", t.ID)
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := callbackClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// handleTask serves the state of an asynchronous task on GET /tasks/{id}.
+func handleTask(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		httpError(resp, req, fmt.Sprintf("method %s not allowed", req.Method), 405)
+		return
+	}
+	id := strings.TrimPrefix(req.URL.Path, "/tasks/")
+	t, ok := tasks.get(id)
+	if !ok {
+		problemError(resp, req, fmt.Sprintf("no task %q, unknown or evicted", id), 404, nil)
+		return
+	}
+	r, err := json.Marshal(t)
+	if err != nil {
+		httpError(resp, req, err.Error(), 500)
+		return
+	}
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Header().Add("Version", Version)
+	resp.WriteHeader(200)
+	_, _ = resp.Write(r)
+}
@@ -0,0 +1,146 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"function/api"
+	"function/workload"
+)
+
+func submit(t *testing.T, query string, header http.Header) (*httptest.ResponseRecorder, api.Task) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "http://example.com/?"+query, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, req)
+	var task api.Task
+	if w.Code == 202 {
+		if err := json.Unmarshal(w.Body.Bytes(), &task); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.String())
+		}
+	}
+	return w, task
+}
+
+func getTask(t *testing.T, path string) (int, api.Task) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com"+path, nil))
+	var task api.Task
+	if w.Code == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), &task); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.String())
+		}
+	}
+	return w.Code, task
+}
+
+// TestHandleAsync ensures that asynchronous requests are acknowledged at
+// once and that their result can be polled.
+func TestHandleAsync(t *testing.T) {
+	for _, header := range []http.Header{nil, {"Prefer": {"respond-async"}}} {
+		start := time.Now()
+		query := "cl=c1&id=r1&ts=50ms&tb=0"
+		if header == nil {
+			query += "&async=true"
+		}
+		w, task := submit(t, query, header)
+		if w.Code != 202 || task.ID == "" || task.Status != api.TaskPending || time.Since(start) > 40*time.Millisecond {
+			t.Fatalf("unexpected acknowledgement %v: %s", w.Code, w.Body.String())
+		}
+		loc := w.Header().Get("Location")
+		if code, got := getTask(t, loc); code != 200 || got.ID != task.ID || got.Status == api.TaskDone {
+			t.Errorf("unexpected task %v: %+v", code, got)
+		}
+		if err := tasks.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		code, got := getTask(t, loc)
+		if code != 200 || got.Status != api.TaskDone || got.Result == nil || got.Result.ID != "r1" || got.Finished < got.Submitted {
+			t.Errorf("unexpected task %v: %+v", code, got)
+		}
+		if got.Result != nil && got.Result.Timing.Idle < int64(50*time.Millisecond) {
+			t.Errorf("unexpected timing: %+v", got.Result.Timing)
+		}
+	}
+	if code, _ := getTask(t, "/tasks/unknown"); code != 404 {
+		t.Errorf("unknown task: unexpected response code %v", code)
+	}
+	if w, _ := submit(t, "cl=c1&ts=0&tb=0&async=maybe&cb=ftp://x", nil); w.Code != 400 {
+		t.Errorf("bad parameters: unexpected response code %v", w.Code)
+	}
+}
+
+// TestHandleAsyncCallback ensures that finished tasks are posted to their
+// callback, without the credentials of the instance, and that its answer
+// is recorded.
+func TestHandleAsyncCallback(t *testing.T) {
+	posted := make(chan api.Task, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("callback carries credentials")
+		}
+		var task api.Task
+		_ = json.NewDecoder(r.Body).Decode(&task)
+		posted <- task
+		w.WriteHeader(204)
+	}))
+	defer srv.Close()
+	defer func(rt http.RoundTripper) { workload.Client.Transport = rt }(workload.Client.Transport)
+	u, _ := url.Parse(srv.URL)
+	workload.Client.Transport = &authTransport{auth: Auth{Tokens: []string{"s3cret"}}, hosts: map[string]bool{u.Host: true}, base: http.DefaultTransport}
+
+	w, task := submit(t, "cl=c1&ts=0&tb=0&cb="+url.QueryEscape(srv.URL+"/done"), nil)
+	if w.Code != 202 || task.Callback == nil || task.Callback.URL != srv.URL+"/done" {
+		t.Fatalf("unexpected acknowledgement %v: %s", w.Code, w.Body.String())
+	}
+	if got := <-posted; got.ID != task.ID || got.Status != api.TaskDone || got.Result == nil {
+		t.Errorf("unexpected callback: %+v", got)
+	}
+	if err := tasks.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := getTask(t, "/tasks/"+task.ID); got.Callback == nil || got.Callback.Status != 204 || got.Callback.Error != "" {
+		t.Errorf("unexpected callback: %+v", got.Callback)
+	}
+}
+
+// TestHandleAsyncRetention ensures that finished tasks are evicted to make
+// room for new ones, and that submissions are refused once the store is
+// full of unfinished tasks.
+func TestHandleAsyncRetention(t *testing.T) {
+	defer func(s *taskStore) { tasks = s }(tasks)
+	tasks = &taskStore{max: 1, ttl: time.Hour, tasks: map[string]*api.Task{}}
+
+	_, first := submit(t, "cl=c1&ts=0&tb=0&async=1", nil)
+	if err := tasks.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	w, second := submit(t, "cl=c1&ts=100ms&tb=0&async=1", nil)
+	if w.Code != 202 {
+		t.Fatalf("unexpected response code %v", w.Code)
+	}
+	if code, _ := getTask(t, "/tasks/"+first.ID); code != 404 {
+		t.Errorf("finished task not evicted: %v", code)
+	}
+	if w, _ := submit(t, "cl=c1&ts=0&tb=0&async=1", nil); w.Code != 503 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("full store: unexpected response code %v", w.Code)
+	}
+	if err := tasks.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks.ttl = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if code, _ := getTask(t, "/tasks/"+second.ID); code != 404 {
+		t.Errorf("expired task not evicted: %v", code)
+	}
+}
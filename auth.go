@@ -0,0 +1,217 @@
+package function
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"function/api"
+	"function/workload"
+)
+
+// Auth is how requests authenticate: with one of the bearer Tokens, a query
+// signed with HMACKey, or a client certificate issued by ClientCAs. A
+// request passes when any configured method accepts it, and every request
+// passes when none is configured.
+type Auth struct {
+	Tokens    []string
+	HMACKey   []byte
+	ClientCAs *x509.CertPool
+}
+
+// auth applies to every request of this instance but probes, metrics and
+// schemas. It is read from SIMTASK_AUTH_TOKEN, a comma-separated list of
+// tokens, SIMTASK_AUTH_HMAC_KEY and SIMTASK_AUTH_CLIENT_CA, the path of a
+// PEM bundle of CA certificates.
+var auth = authFromEnv()
+
+func authFromEnv() Auth {
+	var a Auth
+	for _, t := range strings.Split(os.Getenv("SIMTASK_AUTH_TOKEN"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			a.Tokens = append(a.Tokens, t)
+		}
+	}
+	if k := os.Getenv("SIMTASK_AUTH_HMAC_KEY"); k != "" {
+		a.HMACKey = []byte(k)
+	}
+	if path := os.Getenv("SIMTASK_AUTH_CLIENT_CA"); path != "" {
+		pem, err := os.ReadFile(path)
+		a.ClientCAs = x509.NewCertPool()
+		if err != nil || !a.ClientCAs.AppendCertsFromPEM(pem) {
+			// Keep the empty pool, so that no certificate is trusted
+			// rather than authentication being off.
+			logger.Error("no client CA loaded", "path", path, "error", err)
+		}
+	}
+	return a
+}
+
+// SIMTASK_AUTH_* also authenticates the downstream calls of this instance
+// to trusted hosts, which are likely to run other instances of the function.
+func init() {
+	if auth.enabled() {
+		workload.Client.Transport = &authTransport{auth: auth, hosts: trustedHostsFromEnv(), base: http.DefaultTransport}
+	}
+}
+
+// trustedHostsFromEnv returns the hosts that downstream calls may carry the
+// credentials of this instance to: the host of SIMTASK_SELF_URL and the
+// comma-separated SIMTASK_AUTH_TRUSTED_HOSTS, each a host or host:port.
+// Other hosts, such as the ones of call parameters, get no credentials.
+func trustedHostsFromEnv() map[string]bool {
+	hosts := map[string]bool{}
+	if u, err := url.Parse(os.Getenv("SIMTASK_SELF_URL")); err == nil && u.Host != "" {
+		hosts[u.Host] = true
+	}
+	for _, h := range strings.Split(os.Getenv("SIMTASK_AUTH_TRUSTED_HOSTS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts[h] = true
+		}
+	}
+	if len(hosts) == 0 {
+		logger.Warn("no trusted hosts, downstream calls carry no credentials")
+	}
+	return hosts
+}
+
+func (a Auth) enabled() bool {
+	return len(a.Tokens) > 0 || a.HMACKey != nil || a.ClientCAs != nil
+}
+
+// maxSignedBody is the largest body of a signed request, which is read
+// whole to verify its signature before the request is authenticated.
+const maxSignedBody = 1 << 20
+
+// authenticate returns why no configured method accepts req, if so. The
+// signature is checked last, as it needs the body: a body larger than
+// maxSignedBody fails with an *http.MaxBytesError.
+func (a Auth) authenticate(resp http.ResponseWriter, req *http.Request) error {
+	if !a.enabled() {
+		return nil
+	}
+	var errs []error
+	if len(a.Tokens) > 0 {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		for _, t := range a.Tokens {
+			if ok && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				return nil
+			}
+		}
+		errs = append(errs, errors.New("no valid bearer token"))
+	}
+	if a.ClientCAs != nil {
+		err := a.verifyClient(req.TLS)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if a.HMACKey != nil {
+		if !req.URL.Query().Has(api.ParamSig) {
+			return errors.Join(append(errs, errors.New("no signature"))...)
+		}
+		req.Body = http.MaxBytesReader(resp, req.Body, maxSignedBody)
+		body, err := peekBody(req)
+		if err != nil {
+			return err
+		}
+		err = api.VerifyQuery(a.HMACKey, req.Method, req.URL.Path, req.URL.Query(), body, time.Now())
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// peekBody reads the body of req and leaves it to be read again.
+func peekBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	return b, err
+}
+
+// verifyClient checks the client certificate of a TLS connection against
+// the client CAs, whether or not the server verified it.
+func (a Auth) verifyClient(cs *tls.ConnectionState) error {
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return errors.New("no client certificate")
+	}
+	inter := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		inter.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         a.ClientCAs,
+		Intermediates: inter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// TLSConfig returns the TLS configuration of servers of the function, which
+// asks for client certificates when SIMTASK_AUTH_CLIENT_CA is set.
+func TLSConfig() *tls.Config {
+	if auth.ClientCAs == nil {
+		return &tls.Config{}
+	}
+	return &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: auth.ClientCAs}
+}
+
+// signTTL is how long the signatures of downstream calls are valid.
+const signTTL = time.Minute
+
+// authTransport adds the credentials of the instance to requests to the
+// trusted hosts: its first token, or a signature of the query and body.
+type authTransport struct {
+	auth  Auth
+	hosts map[string]bool
+	base  http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.hosts[req.URL.Host] && !t.hosts[req.URL.Hostname()] {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if len(t.auth.Tokens) > 0 {
+		req.Header.Set("Authorization", "Bearer "+t.auth.Tokens[0])
+	} else if t.auth.HMACKey != nil {
+		q, path := req.URL.Query(), req.URL.Path
+		if path == "" {
+			path = "/"
+		}
+		// Read the body from a copy when possible, leaving the one of the
+		// caller unread.
+		var body []byte
+		var err error
+		if req.GetBody != nil {
+			var rc io.ReadCloser
+			if rc, err = req.GetBody(); err == nil {
+				body, err = io.ReadAll(rc)
+				rc.Close()
+			}
+		} else {
+			body, err = peekBody(req)
+		}
+		if err != nil {
+			return nil, err
+		}
+		api.SignQuery(t.auth.HMACKey, req.Method, path, q, body, time.Now().Add(signTTL))
+		req.URL.RawQuery = q.Encode()
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,185 @@
+package function
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"function/api"
+	"function/workload"
+)
+
+func serveFunction(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handle(r.Context(), w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestHandleAuthToken ensures that a configured token is required but for
+// probes and their bare GET of /, and that downstream calls to trusted
+// hosts carry it.
+func TestHandleAuthToken(t *testing.T) {
+	defer func(a Auth, rt http.RoundTripper) { auth, workload.Client.Transport = a, rt }(auth, workload.Client.Transport)
+	auth = Auth{Tokens: []string{"old", "s3cret"}}
+	srv := serveFunction(t)
+	u, _ := url.Parse(srv.URL)
+	transport := &authTransport{auth: Auth{Tokens: []string{"s3cret"}}, base: http.DefaultTransport}
+	workload.Client.Transport = transport
+
+	for path, want := range map[string]int{
+		"GET /?cl=1&ts=0&tb=0": 401, "GET /dag": 401, "GET /healthz": 200, "GET /metrics": 200, "GET /": 200,
+		"POST /readyz?mode=down": 401,
+	} {
+		method, target, _ := strings.Cut(path, " ")
+		w := httptest.NewRecorder()
+		Handle(context.Background(), w, httptest.NewRequest(method, "http://example.com"+target, nil))
+		if w.Code != want {
+			t.Errorf("%s: unexpected response code %v", path, w.Code)
+		}
+		if want == 401 && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate header", path)
+		}
+	}
+
+	// Only calls to trusted hosts carry the token.
+	for _, hosts := range []map[string]bool{{u.Host: true}, {u.Hostname(): true}, {}} {
+		transport.hosts = hosts
+		req := httptest.NewRequest("GET", "http://example.com/?cl=1&ts=0&tb=0&call="+url.QueryEscape(srv.URL+"/?ts=0&tb=0"), nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		Handle(context.Background(), w, req)
+		var body api.Response
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.String())
+		}
+		want := map[bool]int{true: 200, false: 401}[len(hosts) > 0]
+		if calls := body.Stages[2].Calls; len(calls) != 1 || calls[0].Status != want {
+			t.Errorf("trusting %v: unexpected calls: %+v", hosts, calls)
+		}
+	}
+}
+
+// TestHandleAuthHMAC ensures that signed queries pass until they expire,
+// and that altered ones, or ones with an altered body, do not.
+func TestHandleAuthHMAC(t *testing.T) {
+	defer func(a Auth) { auth = a }(auth)
+	auth = Auth{HMACKey: []byte("key")}
+	code := func(q url.Values) int {
+		w := httptest.NewRecorder()
+		Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?"+q.Encode(), nil))
+		return w.Code
+	}
+	q := url.Values{"cl": {"1"}, "ts": {"0"}, "tb": {"0"}}
+	api.SignQuery([]byte("key"), "GET", "/", q, nil, time.Now().Add(time.Minute))
+	if c := code(q); c != 200 {
+		t.Errorf("signed: unexpected response code %v", c)
+	}
+	q.Set("tb", "10s")
+	if c := code(q); c != 401 {
+		t.Errorf("altered: unexpected response code %v", c)
+	}
+	api.SignQuery([]byte("key"), "GET", "/", q, nil, time.Now().Add(-time.Second))
+	if c := code(q); c != 401 {
+		t.Errorf("expired: unexpected response code %v", c)
+	}
+	api.SignQuery([]byte("other"), "GET", "/", q, nil, time.Now().Add(time.Minute))
+	if c := code(q); c != 401 {
+		t.Errorf("other key: unexpected response code %v", c)
+	}
+
+	// The signature covers the body.
+	q.Set("tb", "0")
+	body := `{"calls": []}`
+	api.SignQuery([]byte("key"), "POST", "/", q, []byte(body), time.Now().Add(time.Minute))
+	for b, want := range map[string]int{body: 200, `{"calls": [{"url": "http://x"}]}`: 401} {
+		w := httptest.NewRecorder()
+		Handle(context.Background(), w, httptest.NewRequest("POST", "http://example.com/?"+q.Encode(), strings.NewReader(b)))
+		if w.Code != want {
+			t.Errorf("body %s: unexpected response code %v", b, w.Code)
+		}
+	}
+
+	// Bodies are read for their signature only up to a limit.
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("POST", "http://example.com/?"+q.Encode(), strings.NewReader(strings.Repeat(" ", maxSignedBody+1))))
+	if w.Code != 413 {
+		t.Errorf("large body: unexpected response code %v", w.Code)
+	}
+
+	// Downstream calls to trusted hosts sign their query and body.
+	defer func(rt http.RoundTripper) { workload.Client.Transport = rt }(workload.Client.Transport)
+	srv := serveFunction(t)
+	u, _ := url.Parse(srv.URL)
+	workload.Client.Transport = &authTransport{auth: auth, hosts: map[string]bool{u.Host: true}, base: http.DefaultTransport}
+	body = `{"calls": [{"url": "` + srv.URL + `/?ts=0&tb=0", "next": {"calls": []}}]}`
+	api.SignQuery([]byte("key"), "POST", "/", q, []byte(body), time.Now().Add(time.Minute))
+	w = httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("POST", "http://example.com/?"+q.Encode(), strings.NewReader(body)))
+	var res api.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	if calls := res.Stages[2].Calls; len(calls) != 1 || calls[0].Status != 200 {
+		t.Errorf("unexpected calls: %+v", calls)
+	}
+}
+
+// TestHandleAuthMTLS ensures that client certificates issued by the client
+// CA authenticate requests.
+func TestHandleAuthMTLS(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ca"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "client"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(a Auth) { auth = a }(auth)
+	auth = Auth{ClientCAs: x509.NewCertPool()}
+	auth.ClientCAs.AddCert(ca)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handle(r.Context(), w, r)
+	}))
+	srv.TLS = TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	c := srv.Client()
+	c.Transport.(*http.Transport).DisableKeepAlives = true
+	for _, certs := range [][]tls.Certificate{nil, {{Certificate: [][]byte{der}, PrivateKey: key}}} {
+		c.Transport.(*http.Transport).TLSClientConfig.Certificates = certs
+		resp, err := c.Get(srv.URL + "/?cl=1&ts=0&tb=0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if want := map[bool]int{false: 401, true: 200}[certs != nil]; resp.StatusCode != want {
+			t.Errorf("with %d certificates: unexpected response code %v", len(certs), resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"function/api"
+	"function/workload"
+)
+
+// parseBatchMode reads how the tasks of a batch run: bm, seq (default) or
+// par, and bf, the most tasks run at a time in parallel, 0 for all.
+func parseBatchMode(params url.Values) (string, int, error) {
+	mode, fanout := workload.CallSequential, 0
+	var errs []error
+	switch bm := params.Get("bm"); bm {
+	case "", workload.CallSequential:
+	case workload.CallParallel:
+		mode = bm
+	default:
+		errs = append(errs, badParam("bm", "must be seq or par"))
+	}
+	if params.Has("bf") {
+		bf, err := strconv.Atoi(params.Get("bf"))
+		if err != nil || bf < 0 {
+			errs = append(errs, badParam("bf", "must be a non-negative integer"))
+		}
+		fanout = bf
+	}
+	return mode, fanout, errors.Join(errs...)
+}
+
+// batchTask returns the request of the task at index i of a batch: a GET
+// with the parameters of the batch overridden by the ones of the task. The
+// id of the task defaults to the one of the batch followed by i, like the
+// ids of downstream calls, and so does its seed, offset by i.
+func batchTask(req *http.Request, i int, task map[string]string) (*http.Request, url.Values) {
+	params := req.URL.Query()
+	params.Del("bm")
+	params.Del("bf")
+	if params.Has("seed") && task["seed"] == "" {
+		if seed, err := strconv.ParseInt(params.Get("seed"), 10, 64); err == nil {
+			params.Set("seed", strconv.FormatInt(seed+int64(i), 10))
+		}
+	}
+	params.Set("id", params.Get("id")+"."+strconv.Itoa(i))
+	for k, v := range task {
+		params.Set(k, v)
+	}
+	return queryRequest(req, params), params
+}
+
+// itemErrors locates the parameter errors of the task at index i.
+func itemErrors(i int, err error) error {
+	var errs []error
+	for _, e := range flattenErrors(err) {
+		p := &paramError{Reason: e.Error()}
+		errors.As(e, &p)
+		item := *p
+		item.Item = fmt.Sprintf("tasks[%d].", i)
+		errs = append(errs, &item)
+	}
+	return errors.Join(errs...)
+}
+
+// handleBatch runs the tasks in the JSON array of a POST to /batch, each an
+// object of simulation parameters, and replies with all their results. Every
+// task is checked before any runs.
+func handleBatch(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		httpError(resp, req, fmt.Sprintf("method %s not allowed", req.Method), 405)
+		return
+	}
+	params := req.URL.Query()
+	var items []map[string]string
+	if err := json.NewDecoder(req.Body).Decode(&items); err != nil {
+		rejectParams(resp, req, badParam("body", fmt.Sprintf("bad batch: %v", err)))
+		return
+	}
+	mode, fanout, err := parseBatchMode(params)
+	errs := []error{err}
+	if !params.Has("cl") {
+		errs = append(errs, badParam("cl", "required"))
+	}
+	if limits.BatchFanout > 0 && fanout > limits.BatchFanout {
+		errs = append(errs, &paramError{Name: "bf", Reason: fmt.Sprintf("above the maximum of %d", limits.BatchFanout), Limit: true})
+	} else if limits.BatchFanout > 0 && fanout == 0 {
+		fanout = limits.BatchFanout
+	}
+	// The tasks of a batch above the limit are not checked.
+	sims := make([]simulation, len(items))
+	if limits.Batch > 0 && len(items) > limits.Batch {
+		errs = append(errs, &paramError{Name: "tasks", Reason: fmt.Sprintf("more than %d tasks", limits.Batch), Limit: true})
+		sims = nil
+	}
+	for i := range sims {
+		treq, tparams := batchTask(req, i, items[i])
+		sim, err := parseSimulation(treq, tparams)
+		if err == nil && sim.async {
+			err = badParam("async", "not supported in batches")
+		}
+		if err != nil {
+			errs = append(errs, itemErrors(i, err))
+		}
+		sims[i] = sim
+	}
+	if err := errors.Join(errs...); err != nil {
+		rejectParams(resp, req, err)
+		return
+	}
+
+	cold := false
+	coldStart.Do(func() {
+		coldStarts.Inc()
+		cold = true
+	})
+	ctx, span := startRequestSpan(req, "simtask.batch")
+	defer span.End()
+	rlog := requestLogger(req, params)
+	rlog.Debug("batch started", "tasks", len(sims), "mode", mode, "fanout", fanout)
+	batch := api.Batch{
+		Version: api.Version,
+		Client:  params.Get("cl"),
+		ID:      params.Get("id"),
+		Mode:    mode,
+		Fanout:  fanout,
+		Results: make([]api.BatchResult, len(sims)),
+	}
+	start := time.Now()
+	run := func(i int) {
+		batch.Results[i] = runBatchTask(ctx, sims[i], cold && i == 0)
+	}
+	if mode == workload.CallSequential {
+		for i := range sims {
+			run(i)
+		}
+	} else {
+		if fanout <= 0 {
+			fanout = len(sims)
+		}
+		sem := make(chan struct{}, fanout)
+		var wg sync.WaitGroup
+		for i := range sims {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer wg.Done()
+				run(i)
+				<-sem
+			}(i)
+		}
+		wg.Wait()
+	}
+	end := time.Now()
+	batch.Timing = batchTiming(batch.Results, start, end)
+
+	r, err := json.Marshal(batch)
+	if err != nil {
+		httpError(resp, req, err.Error(), 500)
+		return
+	}
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Header().Add("X-Request-ID", params.Get("id"))
+	resp.Header().Add("Version", Version)
+	resp.WriteHeader(200)
+	_, _ = resp.Write(r)
+	rlog.Info("request", "status", 200, "tasks", len(sims), "total", batch.Timing.Total, "sum", batch.Timing.Tasks)
+}
+
+// runBatchTask runs a task of a batch. Its fault is not injected but
+// recorded in its result, with the status of status faults.
+func runBatchTask(ctx context.Context, sim simulation, cold bool) api.BatchResult {
+	ctx, span := tracer.Start(ctx, "simtask.batch.task")
+	defer span.End()
+	res, _, err := sim.run(ctx, time.Now(), cold)
+	if err != nil {
+		return api.BatchResult{Status: 500, Error: err.Error()}
+	}
+	status := 200
+	if sim.fired {
+		faultsTotal.WithLabelValues(sim.fault.Mode).Inc()
+		if sim.fault.Mode == FaultStatus {
+			status = sim.fault.code()
+		}
+	}
+	return api.BatchResult{Status: status, Response: &res}
+}
+
+func batchTiming(results []api.BatchResult, start, end time.Time) api.BatchTiming {
+	t := api.BatchTiming{Start: start.UnixNano(), End: end.UnixNano(), Total: end.Sub(start).Nanoseconds()}
+	for _, r := range results {
+		if r.Response == nil {
+			continue
+		}
+		rt := r.Response.Timing
+		t.Tasks += rt.Total
+		t.Max = max(t.Max, rt.Total)
+		t.Idle += rt.Idle
+		t.Busy += rt.Busy
+		t.Call += rt.Call
+	}
+	return t
+}
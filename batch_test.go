@@ -0,0 +1,84 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"function/api"
+)
+
+func postBatch(query, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("POST", "http://example.com/batch?"+query, strings.NewReader(body)))
+	return w
+}
+
+// TestHandleBatch ensures that the tasks of a batch run sequentially or in
+// bounded parallel, with their own results and the aggregate timing.
+func TestHandleBatch(t *testing.T) {
+	body := `[{"ts": "50ms"}, {"ts": "50ms", "id": "own"}, {"ts": "50ms", "fm": "status", "fc": "503"}, {"ts": "50ms"}]`
+	for _, tc := range []struct {
+		query    string
+		min, max time.Duration
+	}{
+		{"cl=c1&id=b1&tb=0", 200 * time.Millisecond, time.Second},
+		{"cl=c1&id=b1&tb=0&bm=par&bf=2", 100 * time.Millisecond, 200 * time.Millisecond},
+		{"cl=c1&id=b1&tb=0&bm=par", 50 * time.Millisecond, 100 * time.Millisecond},
+	} {
+		w := postBatch(tc.query, body)
+		var batch api.Batch
+		if err := json.Unmarshal(w.Body.Bytes(), &batch); err != nil {
+			t.Fatalf("%s: %v: %s", tc.query, err, w.Body.String())
+		}
+		if w.Code != 200 || batch.Client != "c1" || len(batch.Results) != 4 {
+			t.Fatalf("%s: unexpected batch %v: %+v", tc.query, w.Code, batch)
+		}
+		for i, id := range []string{"b1.0", "own", "b1.2", "b1.3"} {
+			if r := batch.Results[i]; r.Response == nil || r.Response.ID != id {
+				t.Errorf("%s: unexpected result %d: %+v", tc.query, i, r)
+			}
+		}
+		if r := batch.Results[2]; r.Status != 503 || r.Response.Fault == nil {
+			t.Errorf("%s: unexpected faulty result: %+v", tc.query, r)
+		}
+		tm := batch.Timing
+		if tm.Total < int64(tc.min) || tm.Total > int64(tc.max) || tm.Tasks < int64(200*time.Millisecond) || tm.Max > tm.Total || tm.Idle > tm.Tasks {
+			t.Errorf("%s: unexpected timing: %+v", tc.query, tm)
+		}
+	}
+}
+
+// TestHandleBatchInvalid ensures that a batch is rejected as a whole, with
+// the invalid parameters of each task, and that its fan-out is bounded.
+func TestHandleBatchInvalid(t *testing.T) {
+	defer func(l Limits) { limits = l }(limits)
+	limits = Limits{Batch: 2, BatchFanout: 2}
+	for query, tc := range map[string]struct{ body, names string }{
+		"cl=c1&tb=0&bm=all": {`[{"ts": "-1"}, {"ts": "0", "async": "true"}]`, "bm tasks[0].ts tasks[1].async"},
+		"cl=c1&tb=0&bf=3":   {`[{"ts": "-1"}, {"ts": "0"}, {"ts": "0"}]`, "bf tasks"},
+	} {
+		w := postBatch(query, tc.body)
+		var p problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.String())
+		}
+		var names []string
+		for _, ip := range p.InvalidParams {
+			names = append(names, ip.Name)
+		}
+		if w.Code != 400 || strings.Join(names, " ") != tc.names {
+			t.Errorf("%s: unexpected rejection %v: %+v", query, w.Code, p)
+		}
+	}
+	var batch api.Batch
+	if w := postBatch("cl=c1&ts=0&tb=0&bm=par", `[{}, {}]`); w.Code != 200 || json.Unmarshal(w.Body.Bytes(), &batch) != nil || batch.Fanout != 2 {
+		t.Errorf("unbounded fan-out: unexpected response %v: %s", w.Code, w.Body.String())
+	}
+	if w := postBatch("cl=c1", `{"ts": "0"}`); w.Code != 400 {
+		t.Errorf("bad body: unexpected response code %v", w.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package function
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+
+	"function/workload"
+)
+
+// parseBusyPolicy overrides def with the bs parameter.
+func parseBusyPolicy(params url.Values, def string) (string, error) {
+	if !params.Has("bs") {
+		return def, nil
+	}
+	p := params.Get("bs")
+	switch p {
+	case workload.StopAll, workload.StopAny:
+	case workload.StopCPU:
+		if !params.Has("tc") {
+			return "", badParam("bs", fmt.Sprintf("%q requires 'tc'", p))
+		}
+	default:
+		return "", badParam("bs", "must be all, any or cpu")
+	}
+	return p, nil
+}
+
+// instanceKernel is the busy kernel used by requests without a bk parameter.
+// The SIMTASK_KERNEL variable sets it.
+var instanceKernel = kernelFromEnv()
+
+func kernelFromEnv() string {
+	k, err := parseKernel(envParams(map[string]string{"SIMTASK_KERNEL": "bk"}), workload.KernelLoop)
+	if err != nil {
+		logger.Warn("ignoring instance kernel", "error", err)
+		return workload.KernelLoop
+	}
+	return k
+}
+
+// parseKernel overrides def with the bk parameter.
+func parseKernel(params url.Values, def string) (string, error) {
+	if !params.Has("bk") {
+		return def, nil
+	}
+	k := params.Get("bk")
+	if !slices.Contains(workload.Kernels(), k) {
+		return "", badParam("bk", "unknown kernel")
+	}
+	return k, nil
+}
@@ -0,0 +1,63 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"function/workload"
+)
+
+// Calibrate the kernels when the instance starts, ahead of the first request,
+// against the reference rates of SIMTASK_REFERENCE.
+func init() {
+	referenceFromEnv()
+	go workload.CurrentCalibration()
+}
+
+// referenceFromEnv overrides the reference kernel rates with
+// SIMTASK_REFERENCE, a list such as "loop=1.5,hash=0.005" in iterations per
+// nanosecond.
+func referenceFromEnv() {
+	v, ok := os.LookupEnv("SIMTASK_REFERENCE")
+	if !ok {
+		return
+	}
+	for _, kv := range strings.Split(v, ",") {
+		k, r, _ := strings.Cut(kv, "=")
+		rate, err := strconv.ParseFloat(r, 64)
+		if err == nil {
+			err = workload.SetReferenceRate(k, rate)
+		}
+		if err != nil {
+			logger.Warn("ignoring bad SIMTASK_REFERENCE entry", "entry", kv)
+		}
+	}
+}
+
+// handleCalibration serves the calibration table on GET and measures it
+// again on POST.
+func handleCalibration(resp http.ResponseWriter, req *http.Request) {
+	var c workload.Calibration
+	switch req.Method {
+	case http.MethodGet:
+		c = workload.CurrentCalibration()
+	case http.MethodPost:
+		c = workload.Recalibrate()
+	default:
+		httpError(resp, req, fmt.Sprintf("method %s not allowed", req.Method), 405)
+		return
+	}
+	r, err := json.Marshal(c)
+	if err != nil {
+		httpError(resp, req, err.Error(), 500)
+		return
+	}
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Header().Add("Version", Version)
+	resp.WriteHeader(200)
+	_, _ = resp.Write(r)
+}
@@ -0,0 +1,58 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"function/api"
+	"function/workload"
+)
+
+// TestHandleCalibration ensures that the calibration endpoint reports a
+// positive rate and speed factor for every kernel.
+func TestHandleCalibration(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/calibration", nil))
+	if w.Code != 200 {
+		t.Fatalf("unexpected response code: %v", w.Code)
+	}
+	var c workload.Calibration
+	if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range workload.Kernels() {
+		if c.Rates[k] <= 0 || c.Speed[k] <= 0 {
+			t.Errorf("%s: bad calibration rate %v speed %v", k, c.Rates[k], c.Speed[k])
+		}
+	}
+}
+
+// TestHandleReferenceWork ensures that reference work is converted into
+// iterations of the selected kernel.
+func TestHandleReferenceWork(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&ts=0&tw=1000000&bk=hash", nil))
+	var body api.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if busy := body.Stages[1]; busy.Kernel != workload.KernelHash || busy.Sampled.Iterations != 5000 || busy.Iterations != 5000 {
+		t.Fatalf("unexpected busy stage: %+v", busy)
+	}
+
+	// Work too large for an iteration count saturates, and is capped.
+	defer func(l Limits) { limits = l }(limits)
+	limits = Limits{Iterations: 1000}
+	w = httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&ts=0&tw=9000000000000000000", nil))
+	body = api.Response{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	if busy := body.Stages[1]; busy.Sampled.Iterations != math.MaxInt64 || busy.Iterations != 1000 {
+		t.Errorf("unexpected busy stage: %+v", busy)
+	}
+}
@@ -0,0 +1,84 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"function/workload"
+)
+
+// SIMTASK_CALL_TIMEOUT bounds each downstream call, in ns or with a unit.
+func init() {
+	workload.Client.Timeout = callTimeoutFromEnv()
+}
+
+// defaultCallTimeout bounds calls when SIMTASK_CALL_TIMEOUT is unset, so
+// that a stuck downstream function cannot hold a task forever.
+const defaultCallTimeout = 5 * time.Minute
+
+func callTimeoutFromEnv() time.Duration {
+	if v, ok := os.LookupEnv("SIMTASK_CALL_TIMEOUT"); ok {
+		d, err := parseDuration(v)
+		if err == nil {
+			return d
+		}
+		logger.Warn("ignoring bad SIMTASK_CALL_TIMEOUT", "value", v, "error", err)
+	}
+	return defaultCallTimeout
+}
+
+// parseCalls reads the call stage from the body of a POST request and
+// appends the calls of the call parameters, each a downstream URL with its
+// task in the query, or else takes def, if any. cm and cf override the mode
+// and fan-out.
+func parseCalls(req *http.Request, params url.Values, def *workload.CallStage) (workload.CallStage, error) {
+	var stage workload.CallStage
+	if req.Method == http.MethodPost && req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return workload.CallStage{}, err
+		}
+		if len(bytes.TrimSpace(b)) > 0 {
+			if err := json.Unmarshal(b, &stage); err != nil {
+				return workload.CallStage{}, badParam("body", fmt.Sprintf("bad call stage: %v", err))
+			}
+		}
+	}
+	for _, u := range params["call"] {
+		stage.Calls = append(stage.Calls, workload.Call{URL: u})
+	}
+	if len(stage.Calls) == 0 && def != nil {
+		stage = *def
+	}
+	if params.Has("cm") {
+		stage.Mode = params.Get("cm")
+	}
+	if params.Has("cf") {
+		cf, err := strconv.Atoi(params.Get("cf"))
+		if err != nil || cf < 0 {
+			return workload.CallStage{}, badParam("cf", "must be a non-negative integer")
+		}
+		stage.Fanout = cf
+	}
+	if err := stage.Validate(); err != nil {
+		return workload.CallStage{}, badParam("call", err.Error())
+	}
+	return stage, nil
+}
+
+// requestOrigin returns the origin of the downstream calls of a request.
+func requestOrigin(req *http.Request, params url.Values) workload.Origin {
+	return workload.Origin{
+		Client:     params.Get("cl"),
+		ID:         params.Get("id"),
+		T0:         params.Get("t0"),
+		Experiment: experiment(req, params),
+	}
+}
@@ -0,0 +1,92 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"function/api"
+	"function/workload"
+)
+
+// TestHandleCalls ensures that a request calls its downstream functions,
+// including their own calls, and embeds their responses.
+func TestHandleCalls(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handle(r.Context(), w, r)
+	}))
+	defer srv.Close()
+
+	stage := workload.CallStage{Mode: workload.CallParallel, Fanout: 1, Calls: []workload.Call{
+		{URL: srv.URL + "/?ts=0&tb=0"},
+		{URL: srv.URL, Params: map[string]string{"ts": "0", "tb": "0"}, Next: &workload.CallStage{
+			Calls: []workload.Call{{URL: srv.URL + "/?ts=0&tb=0", Host: "leaf.example.com"}},
+		}},
+	}}
+	b, _ := json.Marshal(stage)
+	res, err := http.Post(srv.URL+"/?cl=1&id=r&ts=0&tb=0", "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var body api.Response
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	calls := body.Stages[len(body.Stages)-1].Calls
+	if len(calls) != 2 {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+	for _, c := range calls {
+		if c.Status != 200 || c.Latency <= 0 || c.Error != "" {
+			t.Errorf("unexpected call result: %+v", c)
+		}
+	}
+	var child api.Response
+	if err := json.Unmarshal(calls[1].Body, &child); err != nil {
+		t.Fatal(err)
+	}
+	if n := child.Stages[len(child.Stages)-1].Calls; len(n) != 1 || n[0].Status != 200 || n[0].Host != "leaf.example.com" {
+		t.Errorf("unexpected nested calls: %+v", n)
+	}
+}
+
+// TestHandleCallsLargeBody ensures that only the first MaxCallBody bytes of
+// a downstream response are embedded.
+func TestHandleCallsLargeBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 2*workload.MaxCallBody))
+	}))
+	defer srv.Close()
+
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&ts=0&tb=0&call="+srv.URL, nil))
+	var body api.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	calls := body.Stages[len(body.Stages)-1].Calls
+	if len(calls) != 1 {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+	var got string
+	if json.Unmarshal(calls[0].Body, &got) != nil || len(got) != workload.MaxCallBody || !strings.Contains(calls[0].Error, "cut off") {
+		t.Errorf("unexpected call result: %d bytes, %q", len(got), calls[0].Error)
+	}
+}
+
+// TestCallTimeoutFromEnv ensures that the call timeout takes units and
+// falls back to its default when malformed.
+func TestCallTimeoutFromEnv(t *testing.T) {
+	for v, want := range map[string]time.Duration{"2s": 2 * time.Second, "1000": time.Microsecond, "x": defaultCallTimeout, "-1s": defaultCallTimeout} {
+		t.Setenv("SIMTASK_CALL_TIMEOUT", v)
+		if got := callTimeoutFromEnv(); got != want {
+			t.Errorf("%s: got %v, want %v", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Package client builds simtask requests and decodes their responses, for
+// load generators and other Go tooling.
+//
+//	c := client.New("http://200.144.244.220:10080", client.Pool{MaxConnsPerHost: 64})
+//	c.Host = "fn.default.knative.dev"
+//	res, err := c.Do(ctx, client.NewRequest("c1").ID("r1").Idle(150*time.Millisecond).Busy(20*time.Millisecond))
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"function/api"
+	"function/workload"
+)
+
+// Response formats a Client can decode.
+const (
+	FormatJSON     = "application/json"
+	FormatMsgpack  = "application/msgpack"
+	FormatProtobuf = "application/protobuf"
+)
+
+// Pool controls the connections a Client keeps to the function. Zero
+// fields keep the defaults of http.DefaultTransport; note that it keeps only
+// 2 idle connections per host, which load generators usually raise.
+// TLSClientConfig holds the client certificate of mTLS authentication.
+type Pool struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	DisableKeepAlives   bool
+	TLSClientConfig     *tls.Config
+}
+
+// Client sends simulation requests to a simtask function. Host, when set,
+// is the Host header of every request, such as fn.default.knative.dev for
+// Knative routing through an ingress IP; Format is the encoding requested
+// with Accept, FormatJSON by default.
+//
+// Token, when set, is sent as a bearer token, and HMACKey signs the query
+// and body of every request for SignTTL, a minute by default.
+type Client struct {
+	BaseURL string
+	Host    string
+	Format  string
+	HTTP    *http.Client
+	Token   string
+	HMACKey []byte
+	SignTTL time.Duration
+}
+
+// New returns a client of the function at baseURL with its own connection
+// pool.
+func New(baseURL string, pool Pool) *Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if pool.MaxIdleConns > 0 {
+		t.MaxIdleConns = pool.MaxIdleConns
+	}
+	if pool.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	}
+	if pool.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = pool.MaxConnsPerHost
+	}
+	if pool.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = pool.IdleConnTimeout
+	}
+	t.DisableKeepAlives = pool.DisableKeepAlives
+	if pool.TLSClientConfig != nil {
+		t.TLSClientConfig = pool.TLSClientConfig
+	}
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: &http.Client{Transport: t}}
+}
+
+// CloseIdleConnections closes the idle connections of the pool.
+func (c *Client) CloseIdleConnections() {
+	c.HTTP.CloseIdleConnections()
+}
+
+// Response is a decoded simulation response. StatusCode is not 200 when a
+// status fault fired, and Sent and Received frame the exchange on the
+// client clock.
+type Response struct {
+	api.Response
+	StatusCode int
+	Header     http.Header
+	Sent       time.Time
+	Received   time.Time
+}
+
+// Do sends the request and decodes its response. Responses that are not
+// simulation results, such as validation errors, are returned as an
+// *Error.
+func (c *Client) Do(ctx context.Context, r *Request) (*Response, error) {
+	req, err := c.NewHTTPRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	res := &Response{Sent: time.Now()}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	res.Received = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	res.StatusCode, res.Header = resp.StatusCode, resp.Header
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch ct {
+	case FormatJSON:
+		err = json.Unmarshal(b, &res.Response)
+	case FormatMsgpack:
+		dec := msgpack.NewDecoder(bytes.NewReader(b))
+		dec.SetCustomStructTag("json")
+		err = dec.Decode(&res.Response)
+	case FormatProtobuf:
+		err = res.Response.UnmarshalProto(b)
+	case "application/problem+json":
+		e := &Error{StatusCode: resp.StatusCode, Body: string(b)}
+		_ = json.Unmarshal(b, e)
+		return nil, e
+	default:
+		return nil, &Error{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s response: %w", ct, err)
+	}
+	return res, nil
+}
+
+// NewHTTPRequest returns the HTTP request Do sends for r, for callers that
+// send it themselves.
+func (c *Client) NewHTTPRequest(ctx context.Context, r *Request) (*http.Request, error) {
+	method, body := http.MethodGet, []byte(nil)
+	if r.calls != nil {
+		var err error
+		if body, err = json.Marshal(r.calls); err != nil {
+			return nil, err
+		}
+		method = http.MethodPost
+	}
+	req, err := c.newRequest(ctx, method, "/", r.query, body)
+	if err != nil {
+		return nil, err
+	}
+	format := c.Format
+	if format == "" {
+		format = FormatJSON
+	}
+	req.Header.Set("Accept", format)
+	if r.host != "" {
+		req.Host = r.host
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// newRequest returns a request to path with the credentials and Host of
+// the client.
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Request, error) {
+	if c.HMACKey != nil {
+		signed := url.Values{}
+		for k, v := range query {
+			signed[k] = v
+		}
+		ttl := c.SignTTL
+		if ttl <= 0 {
+			ttl = time.Minute
+		}
+		api.SignQuery(c.HMACKey, method, path, signed, body, time.Now().Add(ttl))
+		query = signed
+	}
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	req.Host = c.Host
+	return req, nil
+}
+
+// Submit sends the request asynchronously and returns the pending task;
+// Request.Async sets its callback.
+func (c *Client) Submit(ctx context.Context, r *Request) (*api.Task, error) {
+	req, err := c.NewHTTPRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Prefer", "respond-async")
+	return c.doTask(req, http.StatusAccepted)
+}
+
+// Task returns the state of a submitted task, with its result once done.
+func (c *Client) Task(ctx context.Context, id string) (*api.Task, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/tasks/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.doTask(req, http.StatusOK)
+}
+
+func (c *Client) doTask(req *http.Request, status int) (*api.Task, error) {
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != status {
+		e := &Error{StatusCode: resp.StatusCode, Body: string(b)}
+		_ = json.Unmarshal(b, e)
+		return nil, e
+	}
+	var t api.Task
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("decoding task: %w", err)
+	}
+	return &t, nil
+}
+
+// Error is a response that is not a simulation result. Detail and
+// InvalidParams are decoded from problem responses, which list every
+// invalid parameter of rejected requests.
+type Error struct {
+	StatusCode    int            `json:"-"`
+	Body          string         `json:"-"`
+	Detail        string         `json:"detail"`
+	InvalidParams []InvalidParam `json:"invalid-params"`
+}
+
+// InvalidParam is an invalid parameter of a rejected request.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func (e *Error) Error() string {
+	if len(e.InvalidParams) > 0 {
+		var s []string
+		for _, p := range e.InvalidParams {
+			s = append(s, p.Name+": "+p.Reason)
+		}
+		return fmt.Sprintf("simtask: %d %s", e.StatusCode, strings.Join(s, "; "))
+	}
+	return fmt.Sprintf("simtask: %d %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// CallResult is a downstream call with its decoded response, which is nil
+// when the body is not a simulation result.
+type CallResult struct {
+	workload.CallResult
+	Response *api.Response
+}
+
+// Calls returns the downstream calls of the call stages of res, in order,
+// with the simulation results they embed.
+func Calls(res api.Response) []CallResult {
+	var calls []CallResult
+	for _, s := range res.Stages {
+		for _, c := range s.Calls {
+			cr := CallResult{CallResult: c}
+			var child api.Response
+			if json.Unmarshal(c.Body, &child) == nil && child.Version == api.Version {
+				cr.Response = &child
+			}
+			calls = append(calls, cr)
+		}
+	}
+	return calls
+}
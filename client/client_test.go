@@ -0,0 +1,140 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"function"
+	"function/api"
+	"function/workload"
+)
+
+func newServer(t *testing.T, hosts chan<- string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hosts != nil {
+			hosts <- r.Host
+		}
+		function.Handle(r.Context(), w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestDo ensures that query requests are decoded into typed responses in
+// every supported format, with the Host header of the client.
+func TestDo(t *testing.T) {
+	hosts := make(chan string, 10)
+	srv := newServer(t, hosts)
+	c := New(srv.URL, Pool{MaxIdleConnsPerHost: 4})
+	c.Host = "fn.default.knative.dev"
+	for _, format := range []string{"", FormatJSON, FormatMsgpack, FormatProtobuf} {
+		c.Format = format
+		res, err := c.Do(context.Background(), NewRequest("c1").ID("r1").Idle(time.Millisecond).Iterations(100).Kernel("hash"))
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if res.StatusCode != 200 || res.Client != "c1" || res.ID != "r1" || len(res.Stages) != 2 {
+			t.Fatalf("%s: unexpected response: %+v", format, res)
+		}
+		if busy := res.Stages[1]; busy.Iterations != 100 || busy.Kernel != "hash" {
+			t.Errorf("%s: unexpected busy stage: %+v", format, busy)
+		}
+		if res.Timing.Idle < int64(time.Millisecond) || res.Received.Before(res.Sent) {
+			t.Errorf("%s: unexpected timing: %+v", format, res.Timing)
+		}
+		if h := <-hosts; h != c.Host {
+			t.Errorf("%s: unexpected host: %q", format, h)
+		}
+	}
+	var e *Error
+	if _, err := c.Do(context.Background(), NewRequest("c1").Host("other").IdleDist("bad").Busy(-1)); !errors.As(err, &e) {
+		t.Errorf("unexpected error: %v", err)
+	} else if e.StatusCode != 400 || len(e.InvalidParams) != 2 || e.InvalidParams[0].Name != "ts" {
+		t.Errorf("unexpected error: %+v", e)
+	} else if h := <-hosts; h != "other" {
+		t.Errorf("unexpected host: %q", h)
+	}
+}
+
+// TestDoCalls ensures that a call stage is sent as a JSON body and that the
+// responses of downstream calls are decoded.
+func TestDoCalls(t *testing.T) {
+	srv := newServer(t, nil)
+	c := New(srv.URL, Pool{})
+	stage := workload.CallStage{Calls: []workload.Call{{
+		URL:    srv.URL,
+		Params: map[string]string{"ts": "0", "it": "10"},
+		Next:   &workload.CallStage{Calls: []workload.Call{{URL: srv.URL + "/?ts=0&it=20"}}},
+	}}}
+	res, err := c.Do(context.Background(), NewRequest("c1").ID("r1").Idle(0).Iterations(1).Calls(stage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := Calls(res.Response)
+	if len(calls) != 1 || calls[0].Response == nil || calls[0].Response.ID != "r1.0" {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+	grandchildren := Calls(*calls[0].Response)
+	if len(grandchildren) != 1 || grandchildren[0].Response == nil || grandchildren[0].Response.Stages[1].Iterations != 20 {
+		t.Fatalf("unexpected nested calls: %+v", grandchildren)
+	}
+}
+
+// TestDoAuth ensures that the client sends its token and signs its queries.
+func TestDoAuth(t *testing.T) {
+	key := []byte("key")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if r.Header.Get("Authorization") != "Bearer s3cret" &&
+			api.VerifyQuery(key, r.Method, r.URL.Path, r.URL.Query(), body, time.Now()) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		function.Handle(r.Context(), w, r)
+	}))
+	defer srv.Close()
+	c := New(srv.URL, Pool{})
+	for _, cred := range []string{"none", "token", "hmac"} {
+		c.Token, c.HMACKey = "", nil
+		switch cred {
+		case "token":
+			c.Token = "s3cret"
+		case "hmac":
+			c.HMACKey = key
+		}
+		_, err := c.Do(context.Background(), NewRequest("c1").Idle(0).Busy(0).Param("x", "y"))
+		var e *Error
+		if cred == "none" && (!errors.As(err, &e) || e.StatusCode != 401) || cred != "none" && err != nil {
+			t.Errorf("%s: unexpected error: %v", cred, err)
+		}
+	}
+}
+
+// TestSubmit ensures that submitted tasks can be polled until done.
+func TestSubmit(t *testing.T) {
+	c := New(newServer(t, nil).URL, Pool{})
+	task, err := c.Submit(context.Background(), NewRequest("c1").ID("r1").Idle(10*time.Millisecond).Busy(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for task.Status == api.TaskPending || task.Status == api.TaskRunning {
+		time.Sleep(5 * time.Millisecond)
+		if task, err = c.Task(context.Background(), task.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if task.Result == nil || task.Result.ID != "r1" {
+		t.Errorf("unexpected task: %+v", task)
+	}
+	var e *Error
+	if _, err := c.Task(context.Background(), "unknown"); !errors.As(err, &e) || e.StatusCode != 404 {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"function/workload"
+)
+
+// Request builds a simulation request. Targets go in the query; a call
+// stage set with Calls switches the request to a POST with a JSON body.
+// Setters return the request so they can be chained.
+type Request struct {
+	query  url.Values
+	header http.Header
+	host   string
+	calls  *workload.CallStage
+}
+
+// NewRequest returns a request of client cl.
+func NewRequest(cl string) *Request {
+	return &Request{query: url.Values{"cl": {cl}}, header: http.Header{}}
+}
+
+// Query returns the query parameters of the request.
+func (r *Request) Query() url.Values {
+	return r.query
+}
+
+func (r *Request) set(key, value string) *Request {
+	r.query.Set(key, value)
+	return r
+}
+
+func ns(d time.Duration) string {
+	return strconv.FormatInt(d.Nanoseconds(), 10)
+}
+
+// ID sets the request identifier.
+func (r *Request) ID(id string) *Request { return r.set("id", id) }
+
+// T0 sets the virtual experiment timestamp in ns.
+func (r *Request) T0(t0 int64) *Request { return r.set("t0", strconv.FormatInt(t0, 10)) }
+
+// Experiment sets the experiment tag.
+func (r *Request) Experiment(exp string) *Request { return r.set("exp", exp) }
+
+// Idle sets the idle target.
+func (r *Request) Idle(d time.Duration) *Request { return r.set("ts", ns(d)) }
+
+// IdleDist sets the idle target to a distribution spec in ns, such as
+// "exp:1000000".
+func (r *Request) IdleDist(spec string) *Request { return r.set("ts", spec) }
+
+// IdleMethod sets the idle method.
+func (r *Request) IdleMethod(im string) *Request { return r.set("im", im) }
+
+// Busy sets the busy wall time target.
+func (r *Request) Busy(d time.Duration) *Request { return r.set("tb", ns(d)) }
+
+// BusyDist sets the busy wall time target to a distribution spec in ns.
+func (r *Request) BusyDist(spec string) *Request { return r.set("tb", spec) }
+
+// Iterations sets the busy iterations target.
+func (r *Request) Iterations(it int64) *Request { return r.set("it", strconv.FormatInt(it, 10)) }
+
+// IterationsDist sets the busy iterations target to a distribution spec.
+func (r *Request) IterationsDist(spec string) *Request { return r.set("it", spec) }
+
+// Work sets the busy target to work taking d on the reference hardware.
+func (r *Request) Work(d time.Duration) *Request { return r.set("tw", ns(d)) }
+
+// CPU sets the busy thread CPU time target.
+func (r *Request) CPU(d time.Duration) *Request { return r.set("tc", ns(d)) }
+
+// Policy sets the busy stop policy.
+func (r *Request) Policy(bs string) *Request { return r.set("bs", bs) }
+
+// Kernel sets the busy kernel.
+func (r *Request) Kernel(bk string) *Request { return r.set("bk", bk) }
+
+// Seed seeds the random decisions of the request.
+func (r *Request) Seed(seed int64) *Request { return r.set("seed", strconv.FormatInt(seed, 10)) }
+
+// Tail sets the tail latency injection.
+func (r *Request) Tail(t workload.Tail) *Request {
+	r.set("lp", strconv.FormatFloat(t.P, 'g', -1, 64))
+	if t.Stage != "" {
+		r.set("ls", t.Stage)
+	}
+	if t.Factor != 0 {
+		r.set("lx", strconv.FormatFloat(t.Factor, 'g', -1, 64))
+	}
+	if t.Extra != 0 {
+		r.set("la", ns(t.Extra))
+	}
+	if t.Alpha != 0 {
+		r.set("lpa", strconv.FormatFloat(t.Alpha, 'g', -1, 64))
+		r.set("lpm", ns(t.Scale))
+	}
+	return r
+}
+
+// Fault injects a fault of the given mode with probability p. code is the
+// status or exit code, 0 for the default.
+func (r *Request) Fault(mode string, p float64, code int) *Request {
+	r.set("fm", mode).set("fp", strconv.FormatFloat(p, 'g', -1, 64))
+	if code != 0 {
+		r.set("fc", strconv.Itoa(code))
+	}
+	return r
+}
+
+// Shaping delays the first response byte by delay and drips the body at
+// rate bytes per second, 0 for no limit.
+func (r *Request) Shaping(delay time.Duration, rate int64) *Request {
+	r.set("wd", ns(delay))
+	if rate > 0 {
+		r.set("wr", strconv.FormatInt(rate, 10))
+	}
+	return r
+}
+
+// Calls sets the call stage, sent as the JSON body of a POST.
+func (r *Request) Calls(stage workload.CallStage) *Request {
+	r.calls = &stage
+	return r
+}
+
+// Async submits the request asynchronously: the function acknowledges it
+// at once, keeps its result for Client.Task and, when callback is not
+// empty, posts the finished task there.
+func (r *Request) Async(callback string) *Request {
+	r.set("async", "true")
+	if callback != "" {
+		r.set("cb", callback)
+	}
+	return r
+}
+
+// Param sets a custom parameter, echoed by the function.
+func (r *Request) Param(key, value string) *Request { return r.set(key, value) }
+
+// Header sets a request header.
+func (r *Request) Header(key, value string) *Request {
+	r.header.Set(key, value)
+	return r
+}
+
+// Host sets the Host header of the request, overriding the one of the
+// client.
+func (r *Request) Host(host string) *Request {
+	r.host = host
+	return r
+}
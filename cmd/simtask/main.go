@@ -0,0 +1,87 @@
+// Command simtask serves the simtask function as a plain HTTP server,
+// outside of the Knative func runtime.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	function "function"
+)
+
+func main() {
+	addr := flag.String("addr", defaultAddr(), "address to listen on; defaults to :$PORT, or :8080")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 10*time.Second, "maximum duration to read request headers")
+	readTimeout := flag.Duration("read-timeout", 0, "maximum duration to read a whole request, 0 for no limit")
+	writeTimeout := flag.Duration("write-timeout", 0, "maximum duration to write a response, 0 for no limit")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "maximum duration of an idle keep-alive connection")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "maximum duration to drain requests on shutdown")
+	useH2C := flag.Bool("h2c", false, "serve HTTP/2 over cleartext next to HTTP/1.1")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file, to serve HTTPS along with -tls-key")
+	tlsKey := flag.String("tls-key", "", "PEM private key file of -tls-cert")
+	flag.Parse()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		function.Handle(r.Context(), w, r)
+	})
+	useTLS := *tlsCert != "" || *tlsKey != ""
+	if *useH2C && !useTLS {
+		h = h2c.NewHandler(h, &http2.Server{IdleTimeout: *idleTimeout})
+	}
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           h,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		TLSConfig:         function.TLSConfig(),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("serving simtask", "addr", *addr, "h2c", *useH2C, "tls", useTLS, "version", function.Version)
+		if useTLS {
+			errc <- srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+			return
+		}
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+	slog.Info("shutting down", "timeout", *shutdownTimeout)
+	sctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("shutdown", "error", err)
+	}
+	if err := function.Shutdown(sctx); err != nil {
+		slog.Error("shutdown", "error", err)
+	}
+}
+
+func defaultAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
@@ -0,0 +1,64 @@
+package function
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"function/api"
+)
+
+// knownParams are the parameters of simulation requests; the others are
+// client metadata, echoed in the custom section of the response.
+var knownParams = map[string]bool{
+	"cl": true, "id": true, "t0": true, "exp": true, "v": true,
+	"ts": true, "im": true,
+	"tb": true, "it": true, "tw": true, "tc": true, "bs": true, "bk": true,
+	"call": true, "cm": true, "cf": true,
+	"seed": true, "fm": true, "fp": true, "fc": true,
+	"lp": true, "ls": true, "lx": true, "la": true, "lpa": true, "lpm": true,
+	"wd": true, "wr": true,
+	"async": true, "cb": true, "bm": true, "bf": true, "reply": true,
+	api.ParamExpires: true, api.ParamSig: true,
+}
+
+// echoHeaders are the request headers echoed in the custom section of the
+// response, from the comma-separated SIMTASK_ECHO_HEADERS.
+var echoHeaders = echoHeadersFromEnv()
+
+func echoHeadersFromEnv() []string {
+	var headers []string
+	for _, h := range strings.Split(os.Getenv("SIMTASK_ECHO_HEADERS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	return headers
+}
+
+// requestCustom returns the client metadata of a request: its unknown
+// parameters and the echoed headers it carries, or nil if there is none.
+func requestCustom(req *http.Request, params url.Values) *api.Custom {
+	var c api.Custom
+	for k, v := range params {
+		if !knownParams[k] {
+			if c.Params == nil {
+				c.Params = map[string]string{}
+			}
+			c.Params[k] = strings.Join(v, ",")
+		}
+	}
+	for _, h := range echoHeaders {
+		if v := req.Header.Values(h); len(v) > 0 {
+			if c.Headers == nil {
+				c.Headers = map[string]string{}
+			}
+			c.Headers[h] = strings.Join(v, ",")
+		}
+	}
+	if c.Params == nil && c.Headers == nil {
+		return nil
+	}
+	return &c
+}
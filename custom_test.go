@@ -0,0 +1,50 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"function/api"
+)
+
+// TestHandleCustom ensures that unknown parameters and the configured
+// headers are echoed in the response and in the log line.
+func TestHandleCustom(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *slog.Logger, h []string) { logger, echoHeaders = l, h }(logger, echoHeaders)
+	logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	echoHeaders = []string{"X-Run-Id"}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://example.com/?cl=1&ts=0&tb=0&arm=b&tag=x&tag=y", nil)
+	req.Header.Set("X-Run-ID", "7")
+	req.Header.Set("X-Other", "no")
+	Handle(context.Background(), w, req)
+
+	want := &api.Custom{Params: map[string]string{"arm": "b", "tag": "x,y"}, Headers: map[string]string{"X-Run-Id": "7"}}
+	var body api.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(body.Custom, want) {
+		t.Errorf("unexpected custom section: %+v", body.Custom)
+	}
+	var line struct{ Custom *api.Custom }
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if !reflect.DeepEqual(line.Custom, want) {
+		t.Errorf("unexpected log line: %s", buf.String())
+	}
+
+	w = httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&ts=0&tb=0", nil))
+	if bytes.Contains(w.Body.Bytes(), []byte(`"custom"`)) {
+		t.Errorf("unexpected custom section: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,280 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"function/workload"
+)
+
+// Node run conditions accepted by the when field of a DAGNode.
+const (
+	WhenOK     = "ok"     // every dependency succeeded, the default
+	WhenFailed = "failed" // some dependency failed
+	WhenAlways = "always" // every dependency finished, whatever the outcome
+)
+
+// DAG is a workflow of simulated tasks, sent as the JSON body of a POST to
+// /dag.
+type DAG struct {
+	Nodes []DAGNode `json:"nodes"`
+}
+
+// DAGNode is a task of a DAG. It is called once all the nodes in After have
+// finished and When holds, and then only with probability P when P is set,
+// which models conditional branches. URL defaults to this service, when
+// SIMTASK_SELF_URL is set.
+type DAGNode struct {
+	ID     string            `json:"id"`
+	URL    string            `json:"url,omitempty"`
+	Host   string            `json:"host,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+	After  []string          `json:"after,omitempty"`
+	When   string            `json:"when,omitempty"`
+	P      float64           `json:"p,omitempty"`
+}
+
+// DAGNodeResult is the execution of a node. Offset and End are in ns since
+// the start of the DAG; skipped nodes were not called.
+type DAGNodeResult struct {
+	ID      string `json:"id"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Offset  int64  `json:"offset"`
+	End     int64  `json:"end"`
+	workload.CallResult
+}
+
+// DAGResult is the timeline of a DAG execution. CriticalPath is the chain
+// of nodes that determined the Makespan, in ns.
+type DAGResult struct {
+	Start        int64           `json:"start"`
+	Makespan     int64           `json:"makespan"`
+	Nodes        []DAGNodeResult `json:"nodes"`
+	CriticalPath []string        `json:"critical_path"`
+}
+
+// validate checks node ids, dependencies and conditions, and that the
+// nodes have no cycle. The fields in error are located by their node, as in
+// nodes[2].when.
+func (d DAG) validate() error {
+	var errs []error
+	index, ids := map[string]int{}, map[string]bool{}
+	for _, n := range d.Nodes {
+		ids[n.ID] = true
+	}
+	for i, n := range d.Nodes {
+		bad := func(name, reason string) {
+			errs = append(errs, &paramError{Name: name, Reason: reason, Item: fmt.Sprintf("nodes[%d].", i)})
+		}
+		if j, dup := index[n.ID]; dup {
+			bad("id", fmt.Sprintf("duplicate of nodes[%d]", j))
+		} else if n.ID == "" {
+			bad("id", "required")
+		} else {
+			index[n.ID] = i
+		}
+		switch n.When {
+		case "", WhenOK, WhenFailed, WhenAlways:
+		default:
+			bad("when", "must be ok, failed or always")
+		}
+		if n.P < 0 || n.P > 1 {
+			bad("p", "must be from 0 to 1")
+		}
+		if n.URL != "" {
+			if u, err := url.Parse(n.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				bad("url", "must be an http or https URL")
+			}
+		}
+		for _, dep := range n.After {
+			if !ids[dep] {
+				bad("after", fmt.Sprintf("unknown node %q", dep))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	indegree := make([]int, len(d.Nodes))
+	dependents := make([][]int, len(d.Nodes))
+	for i, n := range d.Nodes {
+		for _, dep := range n.After {
+			j := index[dep]
+			indegree[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	var ready []int
+	for i, deg := range indegree {
+		if deg == 0 {
+			ready = append(ready, i)
+		}
+	}
+	seen := 0
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		seen++
+		for _, j := range dependents[i] {
+			if indegree[j]--; indegree[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if seen != len(d.Nodes) {
+		return badParam("nodes", "dependency cycle")
+	}
+	return nil
+}
+
+// run executes every node as soon as its dependencies finish. self is the
+// URL of this service, SIMTASK_SELF_URL, for nodes without one.
+func (d DAG) run(ctx context.Context, origin workload.Origin, self string, rng *rand.Rand) DAGResult {
+	start := time.Now()
+	index := map[string]int{}
+	for i, n := range d.Nodes {
+		index[n.ID] = i
+	}
+	results := make([]DAGNodeResult, len(d.Nodes))
+	done := make([]chan struct{}, len(d.Nodes))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	// Draw the branches up front, so that a seed yields the same ones
+	// whatever the order nodes finish in.
+	taken := make([]bool, len(d.Nodes))
+	for i, n := range d.Nodes {
+		taken[i] = n.P == 0 || rng.Float64() < n.P
+	}
+	for i, n := range d.Nodes {
+		go func(i int, n DAGNode) {
+			defer close(done[i])
+			ok, failed := true, false
+			for _, dep := range n.After {
+				j := index[dep]
+				<-done[j]
+				r := results[j]
+				success := !r.Skipped && r.Error == "" && r.Status >= 200 && r.Status < 300
+				ok = ok && success
+				failed = failed || (!r.Skipped && !success)
+			}
+			run := taken[i]
+			switch n.When {
+			case WhenFailed:
+				run = run && failed
+			case WhenAlways:
+			default:
+				run = run && ok
+			}
+			res := DAGNodeResult{ID: n.ID, Skipped: !run}
+			res.Offset = time.Since(start).Nanoseconds()
+			if run {
+				u := n.URL
+				if u == "" {
+					u = self
+				}
+				res.CallResult = workload.Call{URL: u, Host: n.Host, Params: n.Params}.Do(ctx, origin, n.ID)
+			}
+			res.End = time.Since(start).Nanoseconds()
+			results[i] = res
+		}(i, n)
+	}
+	for _, ch := range done {
+		<-ch
+	}
+	return DAGResult{
+		Start:        start.UnixNano(),
+		Makespan:     time.Since(start).Nanoseconds(),
+		Nodes:        results,
+		CriticalPath: d.criticalPath(results),
+	}
+}
+
+// criticalPath walks back from the called node that finished last through
+// the dependency that released each node, the called one that finished
+// last.
+func (d DAG) criticalPath(results []DAGNodeResult) []string {
+	index := map[string]int{}
+	for i, n := range d.Nodes {
+		index[n.ID] = i
+	}
+	latest := func(ids []int) int {
+		last := -1
+		for _, i := range ids {
+			if !results[i].Skipped && (last < 0 || results[i].End > results[last].End) {
+				last = i
+			}
+		}
+		return last
+	}
+	all := make([]int, len(results))
+	for i := range all {
+		all[i] = i
+	}
+	var path []string
+	for i := latest(all); i >= 0; {
+		path = append([]string{d.Nodes[i].ID}, path...)
+		deps := make([]int, len(d.Nodes[i].After))
+		for k, dep := range d.Nodes[i].After {
+			deps[k] = index[dep]
+		}
+		i = latest(deps)
+	}
+	return path
+}
+
+// handleDAG runs the DAG in the body of a POST request. The cl, id, t0 and
+// seed parameters apply to the whole workflow.
+func handleDAG(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		httpError(resp, req, fmt.Sprintf("method %s not allowed", req.Method), 405)
+		return
+	}
+	var d DAG
+	if err := json.NewDecoder(req.Body).Decode(&d); err != nil {
+		rejectParams(resp, req, badParam("body", fmt.Sprintf("bad dag: %v", err)))
+		return
+	}
+	params := req.URL.Query()
+	rng, err := requestRand(params)
+	errs := []error{err}
+	if !params.Has("cl") {
+		errs = append(errs, badParam("cl", "required"))
+	}
+	// The Host header is up to the client, so nodes only default to this
+	// service when its URL is configured.
+	self := os.Getenv("SIMTASK_SELF_URL")
+	if limits.DAGNodes > 0 && len(d.Nodes) > limits.DAGNodes {
+		errs = append(errs, &paramError{Name: "nodes", Reason: fmt.Sprintf("more than %d nodes", limits.DAGNodes), Limit: true})
+	} else {
+		errs = append(errs, d.validate())
+		for i, n := range d.Nodes {
+			if n.URL == "" && self == "" {
+				errs = append(errs, &paramError{Name: "url", Reason: "required without SIMTASK_SELF_URL", Item: fmt.Sprintf("nodes[%d].", i)})
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		rejectParams(resp, req, err)
+		return
+	}
+	ctx, span := startRequestSpan(req, "simtask.dag")
+	defer span.End()
+	r, err := json.Marshal(d.run(ctx, requestOrigin(req, params), self, rng))
+	if err != nil {
+		httpError(resp, req, err.Error(), 500)
+		return
+	}
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Header().Add("X-Request-ID", params.Get("id"))
+	resp.Header().Add("Version", Version)
+	resp.WriteHeader(200)
+	_, _ = resp.Write(r)
+}
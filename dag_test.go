@@ -0,0 +1,108 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// TestHandleDAG ensures that a DAG runs its nodes after their dependencies,
+// skips untaken branches and reports the critical path.
+func TestHandleDAG(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handle(r.Context(), w, r)
+	}))
+	defer srv.Close()
+	t.Setenv("SIMTASK_SELF_URL", srv.URL+"/")
+
+	d := DAG{Nodes: []DAGNode{
+		{ID: "a", Params: map[string]string{"ts": "0", "tb": "0"}},
+		{ID: "b", Params: map[string]string{"ts": "20000000", "tb": "0"}, After: []string{"a"}},
+		{ID: "c", Params: map[string]string{"ts": "0", "tb": "0"}, After: []string{"a"}},
+		{ID: "d", Params: map[string]string{"ts": "0", "tb": "0"}, After: []string{"b", "c"}},
+		{ID: "e", Params: map[string]string{"ts": "0", "tb": "0"}, After: []string{"d"}, When: WhenFailed},
+	}}
+	b, _ := json.Marshal(d)
+	res, err := http.Post(srv.URL+"/dag?cl=1&id=w", "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var r DAGResult
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Nodes) != 5 {
+		t.Fatalf("unexpected nodes: %+v", r.Nodes)
+	}
+	for _, n := range r.Nodes[:4] {
+		if n.Skipped || n.Status != 200 {
+			t.Errorf("node %s: skipped %v status %d", n.ID, n.Skipped, n.Status)
+		}
+	}
+	if !r.Nodes[4].Skipped {
+		t.Errorf("node e ran without a failed dependency")
+	}
+	if r.Nodes[3].Offset < r.Nodes[1].End {
+		t.Errorf("node d started before its dependency b finished")
+	}
+	if want := []string{"a", "b", "d"}; !reflect.DeepEqual(r.CriticalPath, want) {
+		t.Errorf("critical path %v, want %v", r.CriticalPath, want)
+	}
+}
+
+// TestDAGValidate ensures that malformed DAGs are rejected.
+func TestDAGValidate(t *testing.T) {
+	for _, d := range []DAG{
+		{Nodes: []DAGNode{{ID: "a"}, {ID: "a"}}},
+		{Nodes: []DAGNode{{ID: "a", After: []string{"x"}}}},
+		{Nodes: []DAGNode{{ID: "a", After: []string{"b"}}, {ID: "b", After: []string{"a"}}}},
+		{Nodes: []DAGNode{{ID: "a", When: "sometimes"}}},
+	} {
+		if err := d.validate(); err == nil {
+			t.Errorf("%+v: expected an error", d)
+		}
+	}
+}
+
+// TestHandleDAGInvalid ensures that malformed DAGs, DAGs above the node
+// limit and nodes without a URL to call are rejected with the fields in
+// error.
+func TestHandleDAGInvalid(t *testing.T) {
+	defer func(l Limits) { limits = l }(limits)
+	limits = Limits{DAGNodes: 2}
+	t.Setenv("SIMTASK_SELF_URL", "http://example.com/")
+	post := func(d DAG) problem {
+		b, _ := json.Marshal(d)
+		w := httptest.NewRecorder()
+		Handle(context.Background(), w, httptest.NewRequest("POST", "http://example.com/dag", bytes.NewReader(b)))
+		var p problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != 400 {
+			t.Fatalf("unexpected response %v: %s", w.Code, w.Body.String())
+		}
+		return p
+	}
+	p := post(DAG{Nodes: []DAGNode{{ID: "a", When: "sometimes"}, {ID: "b", After: []string{"x"}}}})
+	var names []string
+	for _, ip := range p.InvalidParams {
+		names = append(names, ip.Name)
+	}
+	if want := []string{"cl", "nodes[0].when", "nodes[1].after"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("invalid params %v, want %v", names, want)
+	}
+	p = post(DAG{Nodes: []DAGNode{{ID: "a"}, {ID: "b"}, {ID: "c"}}})
+	if len(p.InvalidParams) != 2 || p.InvalidParams[1].Name != "nodes" {
+		t.Errorf("unexpected invalid params: %+v", p.InvalidParams)
+	}
+
+	// Without a URL of its own, the service cannot call itself.
+	t.Setenv("SIMTASK_SELF_URL", "")
+	p = post(DAG{Nodes: []DAGNode{{ID: "a"}, {ID: "b", URL: "http://b.example.com/"}}})
+	if len(p.InvalidParams) != 2 || p.InvalidParams[1].Name != "nodes[0].url" {
+		t.Errorf("unexpected invalid params: %+v", p.InvalidParams)
+	}
+}
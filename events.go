@@ -0,0 +1,182 @@
+package function
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"function/api"
+)
+
+// EventData is the data of the CloudEvents the function accepts, in JSON:
+// a trace record and simulation parameters, which take precedence over it.
+// The Duration of the record, in ns, is split into idle and busy targets by
+// its percentages, and is all busy when both are zero.
+type EventData struct {
+	ServiceRequest
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// event is a CloudEvent of spec version 1.0 received over HTTP, in binary or
+// structured mode.
+type event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+	DataBase64      string          `json:"data_base64"`
+}
+
+// ceStructured is the content type of events in structured mode.
+const ceStructured = "application/cloudevents+json"
+
+// eventSource is the source of reply events, SIMTASK_EVENT_SOURCE or
+// /simtask.
+var eventSource = envOr("SIMTASK_EVENT_SOURCE", "/simtask")
+
+// eventReply is the type of the reply events when the request does not set
+// reply, none by default.
+var eventReply = os.Getenv("SIMTASK_EVENT_REPLY")
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func isEvent(req *http.Request) bool {
+	ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return req.Header.Get("Ce-Specversion") != "" || ct == ceStructured
+}
+
+func isJSON(ct string) bool {
+	ct, _, _ = mime.ParseMediaType(ct)
+	return ct == "" || ct == "application/json" || ct == "text/json" || strings.HasSuffix(ct, "+json")
+}
+
+// readEvent reads the event in the headers and body of req.
+func readEvent(req *http.Request) (event, error) {
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		return event{}, err
+	}
+	var e event
+	if req.Header.Get("Ce-Specversion") != "" {
+		e = event{
+			SpecVersion:     req.Header.Get("Ce-Specversion"),
+			ID:              req.Header.Get("Ce-Id"),
+			Source:          req.Header.Get("Ce-Source"),
+			Type:            req.Header.Get("Ce-Type"),
+			DataContentType: req.Header.Get("Content-Type"),
+			Data:            b,
+		}
+	} else if err := json.Unmarshal(b, &e); err != nil {
+		return event{}, badParam("body", fmt.Sprintf("bad structured event: %v", err))
+	} else if e.DataBase64 != "" {
+		if e.Data, err = base64.StdEncoding.DecodeString(e.DataBase64); err != nil {
+			return event{}, badParam("data_base64", "must be base64")
+		}
+	}
+	var errs []error
+	if e.SpecVersion != "1.0" {
+		errs = append(errs, badParam("specversion", "must be 1.0"))
+	}
+	for _, a := range []struct{ name, value string }{{"id", e.ID}, {"source", e.Source}, {"type", e.Type}} {
+		if a.value == "" {
+			errs = append(errs, badParam(a.name, "required"))
+		}
+	}
+	if !isJSON(e.DataContentType) {
+		errs = append(errs, badParam("datacontenttype", "must be JSON"))
+	}
+	return e, errors.Join(errs...)
+}
+
+// eventParams returns the simulation parameters of the event in req and the
+// type of its reply, if any. The query, such as the one of the subscriber
+// URI of a Trigger, holds defaults; the source and id of the event are the
+// cl and id of the task unless the query sets them, and the data overrides
+// them all. The identifiers of the record are echoed under custom.
+func eventParams(req *http.Request) (url.Values, string, error) {
+	params := req.URL.Query()
+	e, err := readEvent(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if !params.Has("cl") {
+		params.Set("cl", e.Source)
+	}
+	if !params.Has("id") {
+		params.Set("id", e.ID)
+	}
+	if len(strings.TrimSpace(string(e.Data))) > 0 && string(e.Data) != "null" {
+		var data EventData
+		if err := json.Unmarshal(e.Data, &data); err != nil {
+			return nil, "", badParam("data", fmt.Sprintf("bad event data: %v", err))
+		}
+		data.apply(params)
+	}
+	reply := eventReply
+	if params.Has("reply") {
+		reply = params.Get("reply")
+	}
+	return params, reply, nil
+}
+
+func (d EventData) apply(params url.Values) {
+	if r := d.ServiceRequest; r != (ServiceRequest{}) {
+		r.apply(params)
+	}
+	for k, v := range d.Params {
+		params.Set(k, v)
+	}
+}
+
+// apply sets the parameters of the task of a trace record.
+func (r ServiceRequest) apply(params url.Values) {
+	for k, v := range map[string]uint64{
+		"app_id": uint64(r.AppID), "function_id": uint64(r.FunctionID),
+		"event_type": uint64(r.EventType), "request_type": uint64(r.RequestType),
+	} {
+		params.Set(k, strconv.FormatUint(v, 10))
+	}
+	if r.RequestID != 0 {
+		params.Set("id", strconv.FormatUint(r.RequestID, 10))
+	}
+	if r.Ts != 0 {
+		params.Set("t0", strconv.FormatUint(r.Ts, 10))
+	}
+	if r.Duration != 0 {
+		idle, busy := r.Duration*uint64(r.IdlePercent)/100, r.Duration*uint64(r.BusyPercent)/100
+		if r.IdlePercent == 0 && r.BusyPercent == 0 {
+			busy = r.Duration
+		}
+		params.Set("ts", strconv.FormatUint(idle, 10))
+		params.Set("tb", strconv.FormatUint(busy, 10))
+	}
+}
+
+// setReplyHeaders makes the response to an event a reply event of type
+// typ in binary mode, about the request with the id of res.
+func setReplyHeaders(h http.Header, typ string, res api.Response) {
+	h.Set("Ce-Specversion", "1.0")
+	h.Set("Ce-Id", newID())
+	h.Set("Ce-Source", eventSource)
+	h.Set("Ce-Type", typ)
+	h.Set("Ce-Time", time.Now().UTC().Format(time.RFC3339Nano))
+	if res.ID != "" {
+		h.Set("Ce-Subject", res.ID)
+	}
+}
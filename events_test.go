@@ -0,0 +1,92 @@
+package function
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"function/api"
+)
+
+func postEvent(t *testing.T, query string, header map[string]string, body string) (*httptest.ResponseRecorder, api.Response) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "http://example.com/?"+query, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, req)
+	var res api.Response
+	if w.Code == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.String())
+		}
+	}
+	return w, res
+}
+
+// TestHandleEventBinary ensures that trace records sent as binary mode
+// events are expanded into tasks.
+func TestHandleEventBinary(t *testing.T) {
+	header := map[string]string{
+		"Ce-Specversion": "1.0", "Ce-Id": "e1", "Ce-Source": "/trace", "Ce-Type": "dev.trace.request",
+		"Content-Type": "application/json",
+	}
+	record := `{"ts": 1000, "app_id": 7, "function_id": 3, "event_type": 2, "request_type": 1,
+		"idle_percent": 25, "busy_percent": 75, "duration": 40000000, "request_id": 42}`
+	w, res := postEvent(t, "", header, record)
+	if w.Code != 200 || res.Client != "/trace" || res.ID != "42" || res.T0 != "1000" {
+		t.Fatalf("unexpected response %v: %s", w.Code, w.Body.String())
+	}
+	if idle, busy := stage(res, "idle"), stage(res, "busy"); idle.Target.Duration != int64(10*time.Millisecond) || busy.Target.Duration != int64(30*time.Millisecond) {
+		t.Errorf("unexpected targets: %+v %+v", idle.Target, busy.Target)
+	}
+	if res.Custom == nil || res.Custom.Params["event_type"] != "2" || res.Custom.Params["request_type"] != "1" || res.Custom.Params["app_id"] != "7" {
+		t.Errorf("unexpected custom: %+v", res.Custom)
+	}
+	if w.Header().Get("Ce-Type") != "" {
+		t.Errorf("unexpected reply event: %v", w.Header())
+	}
+
+	// Without data, the task comes from the query, and the event id is the
+	// request id.
+	if w, res := postEvent(t, "cl=c1&ts=0&tb=0", header, ""); w.Code != 200 || res.Client != "c1" || res.ID != "e1" {
+		t.Errorf("unexpected response %v: %s", w.Code, w.Body.String())
+	}
+	header["Content-Type"] = "text/plain"
+	if w, _ := postEvent(t, "", header, "hello"); w.Code != 400 {
+		t.Errorf("text data: unexpected response code %v", w.Code)
+	}
+}
+
+// TestHandleEventStructured ensures that structured mode events are
+// accepted, and that replies are events carrying the response.
+func TestHandleEventStructured(t *testing.T) {
+	header := map[string]string{"Content-Type": "application/cloudevents+json"}
+	data := `{"params": {"ts": "5ms", "tb": "0", "exp": "ce"}}`
+	for _, body := range []string{
+		`{"specversion": "1.0", "id": "e2", "source": "/trace", "type": "dev.trace.request", "data": ` + data + `}`,
+		`{"specversion": "1.0", "id": "e2", "source": "/trace", "type": "dev.trace.request", "data_base64": "` + base64.StdEncoding.EncodeToString([]byte(data)) + `"}`,
+	} {
+		w, res := postEvent(t, "reply=dev.simtask.result", header, body)
+		if w.Code != 200 || res.ID != "e2" || res.Experiment != "ce" || res.Timing.Idle < int64(5*time.Millisecond) {
+			t.Fatalf("unexpected response %v: %s", w.Code, w.Body.String())
+		}
+		h := w.Header()
+		if h.Get("Ce-Specversion") != "1.0" || h.Get("Ce-Type") != "dev.simtask.result" || h.Get("Ce-Subject") != "e2" ||
+			h.Get("Ce-Id") == "" || h.Get("Ce-Source") != "/simtask" || h.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected reply headers: %v", h)
+		}
+	}
+
+	w, _ := postEvent(t, "", header, `{"specversion": "0.3", "source": "/trace"}`)
+	var p problem
+	_ = json.Unmarshal(w.Body.Bytes(), &p)
+	if w.Code != 400 || len(p.InvalidParams) != 3 {
+		t.Errorf("unexpected rejection %v: %s", w.Code, w.Body.String())
+	}
+}
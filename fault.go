@@ -0,0 +1,181 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Fault modes accepted by the fm parameter and the SIMTASK_FAULT variable.
+const (
+	FaultStatus = "status" // reply with status Code instead of 200
+	FaultPanic  = "panic"  // panic inside the handler
+	FaultExit   = "exit"   // terminate the instance with exit Code
+	FaultHang   = "hang"   // never reply, until the client goes away
+	// Transport faults, carried out by Shaping.respond.
+	FaultClose    = "close"    // close the connection halfway through the body
+	FaultTruncate = "truncate" // end the response cleanly after half the body
+	FaultLength   = "length"   // announce twice the real Content-Length
+	FaultReset    = "reset"    // reset the TCP connection instead of replying
+)
+
+// Fault describes a failure injected into a request. It fires with
+// probability P. Code is the HTTP status for FaultStatus and the process
+// exit code for FaultExit; zero selects 500 and 1 respectively.
+type Fault struct {
+	Mode string
+	P    float64
+	Code int
+}
+
+// instanceFault applies to every request served by this instance. It is
+// read from SIMTASK_FAULT, SIMTASK_FAULT_P and SIMTASK_FAULT_CODE, and the
+// fm, fp and fc request parameters take precedence over it.
+var instanceFault = faultFromEnv()
+
+// instanceRand draws the random decisions of requests that carry no seed
+// parameter. It is seeded from SIMTASK_SEED when set, so a whole instance
+// can be replayed.
+var instanceRand = rand.New(&lockedSource{src: rand.NewSource(seedFromEnv())})
+
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+func seedFromEnv() int64 {
+	if v, ok := os.LookupEnv("SIMTASK_SEED"); ok {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			return seed
+		}
+		logger.Warn("ignoring bad SIMTASK_SEED", "value", v)
+	}
+	return time.Now().UnixNano()
+}
+
+// envParams maps environment variables onto request parameter names, so
+// instance-wide defaults go through the same parsing as request values.
+func envParams(names map[string]string) url.Values {
+	params := url.Values{}
+	for env, name := range names {
+		if v, ok := os.LookupEnv(env); ok {
+			params.Set(name, v)
+		}
+	}
+	return params
+}
+
+func faultFromEnv() Fault {
+	f, err := parseFault(envParams(map[string]string{
+		"SIMTASK_FAULT":      "fm",
+		"SIMTASK_FAULT_P":    "fp",
+		"SIMTASK_FAULT_CODE": "fc",
+	}), Fault{})
+	if err != nil {
+		logger.Warn("ignoring instance fault", "error", err)
+		return Fault{}
+	}
+	return f
+}
+
+// requestRand returns the random source for a request: a fresh one when the
+// request carries a seed parameter, the shared instance source otherwise.
+func requestRand(params url.Values) (*rand.Rand, error) {
+	if !params.Has("seed") {
+		return instanceRand, nil
+	}
+	seed, err := strconv.ParseInt(params.Get("seed"), 10, 64)
+	if err != nil {
+		return nil, badParam("seed", "must be an integer")
+	}
+	return rand.New(rand.NewSource(seed)), nil
+}
+
+// parseFault overrides def with the fm, fp and fc parameters. Selecting a
+// mode resets the probability to 1 and the code to its default.
+func parseFault(params url.Values, def Fault) (Fault, error) {
+	f := def
+	var errs []error
+	if params.Has("fm") {
+		f = Fault{Mode: params.Get("fm"), P: 1}
+		switch f.Mode {
+		case "", FaultStatus, FaultPanic, FaultExit, FaultHang, FaultClose,
+			FaultTruncate, FaultLength, FaultReset:
+		default:
+			errs = append(errs, badParam("fm", "unknown fault mode"))
+		}
+	}
+	if params.Has("fp") {
+		p, err := strconv.ParseFloat(params.Get("fp"), 64)
+		if err != nil || p < 0 || p > 1 {
+			errs = append(errs, badParam("fp", "must be a probability in [0, 1]"))
+		}
+		f.P = p
+	}
+	if params.Has("fc") {
+		code, err := strconv.Atoi(params.Get("fc"))
+		if err != nil || (f.Mode == FaultStatus && (code < 100 || code > 599)) {
+			errs = append(errs, badParam("fc", "must be an HTTP status or exit code"))
+		}
+		f.Code = code
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Fault{}, err
+	}
+	return f, nil
+}
+
+// Fires draws whether the fault applies to the current request.
+func (f Fault) Fires(rng *rand.Rand) bool {
+	return f.Mode != "" && rng.Float64() < f.P
+}
+
+// code returns Code, or its default for the fault mode.
+func (f Fault) code() int {
+	if f.Code != 0 {
+		return f.Code
+	}
+	switch f.Mode {
+	case FaultStatus:
+		return http.StatusInternalServerError
+	case FaultExit:
+		return 1
+	}
+	return 0
+}
+
+// inject carries out a fired fault that terminates the request without a
+// reply. It reports false for the faults that still reply.
+func (f Fault) inject(req *http.Request) bool {
+	switch f.Mode {
+	case FaultPanic:
+		params := req.URL.Query()
+		panic(fmt.Sprintf("simtask: injected panic cl=%s id=%s", params.Get("cl"), params.Get("id")))
+	case FaultExit:
+		flushTracing()
+		os.Exit(f.code())
+	case FaultHang:
+		<-req.Context().Done()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// TestHandleStatusFault ensures that a status fault with probability 1
+// replies with the requested code and records the fault in the body.
+func TestHandleStatusFault(t *testing.T) {
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "http://example.com/?cl=1&id=2&ts=0&tb=0&fm=status&fc=503", nil)
+	)
+
+	Handle(context.Background(), w, req)
+	res := w.Result()
+	defer res.Body.Close()
+
+	if res.StatusCode != 503 {
+		t.Fatalf("unexpected response code: %v", res.StatusCode)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	fault, ok := body["fault"].(map[string]any)
+	if !ok || fault["mode"] != FaultStatus || fault["code"] != 503.0 {
+		t.Fatalf("unexpected fault record: %v", body["fault"])
+	}
+}
+
+// TestFaultSeed ensures that the same seed fires faults on the same draws.
+func TestFaultSeed(t *testing.T) {
+	f, err := parseFault(url.Values{"fm": {FaultPanic}, "fp": {"0.5"}}, Fault{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := rand.New(rand.NewSource(7)), rand.New(rand.NewSource(7))
+	for i := 0; i < 100; i++ {
+		if f.Fires(a) != f.Fires(b) {
+			t.Fatalf("draw %d differs between equally seeded sources", i)
+		}
+	}
+}
+
+// TestParseFault ensures that bad fault parameters are rejected.
+func TestParseFault(t *testing.T) {
+	for _, q := range []string{"fm=boom", "fp=1.5", "fm=status&fc=42"} {
+		params, _ := url.ParseQuery(q)
+		if _, err := parseFault(params, Fault{}); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
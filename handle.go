@@ -3,21 +3,25 @@ package function
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
-	"strconv"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/load"
-	"github.com/shirou/gopsutil/v3/mem"
-	"github.com/shirou/gopsutil/v3/process"
-	"golang.org/x/sys/unix"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"function/api"
+	"function/workload"
 )
 
 const Version = "0.1.1"
 
+// ServiceRequest is a request record of a trace, which CloudEvents carry
+// as their data.
 type ServiceRequest struct {
 	Ts          uint64 `json:"ts"`
 	AppID       uint16 `json:"app_id"`
@@ -37,139 +41,268 @@ type ServiceRequest struct {
 }
 
 func Handle(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
-	_, _ = cpu.Percent(0, true)
-	_, _ = disk.IOCounters()
-	rt0 := time.Now()
-	params := req.URL.Query()
-	if !params.Has("cl") {
-		resp.WriteHeader(200)
-		_, _ = resp.Write([]byte(""))
+	if req.URL.Path == "/metrics" {
+		metricsHandler.ServeHTTP(resp, req)
 		return
 	}
-	ts, err := strconv.ParseInt(params.Get("ts"), 10, 64)
-	if err != nil {
-		http.Error(resp, "bad 'ts' parameter", 400)
+	resp, done := instrument(resp, req)
+	defer done()
+	// Probes, schemas and the bare GET of / that checks the function is up
+	// need no credentials; reconfiguring probes does.
+	read := req.Method == http.MethodGet || req.Method == http.MethodHead
+	switch req.URL.Path {
+	case "/healthz", "/readyz":
+		if read {
+			handleProbe(resp, req)
+			return
+		}
+	case "/schema", "/schema.proto":
+		handleSchema(resp, req)
 		return
+	case "/":
+		if read && !req.URL.Query().Has("cl") && !isEvent(req) {
+			resp.WriteHeader(200)
+			return
+		}
 	}
-	var tb int64 = 0
-	var it int64 = 0
-	if params.Has("it") {
-		it, err = strconv.ParseInt(params.Get("it"), 10, 64)
-		if err != nil {
-			http.Error(resp, "bad 'it' parameter", 400)
+	if err := auth.authenticate(resp, req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problemError(resp, req, err.Error(), 413, nil)
 			return
 		}
-	} else {
-		tb, err = strconv.ParseInt(params.Get("tb"), 10, 64)
+		unauthenticated.Inc()
+		resp.Header().Set("WWW-Authenticate", `Bearer realm="simtask"`)
+		problemError(resp, req, err.Error(), 401, nil)
+		return
+	}
+	switch req.URL.Path {
+	case "/healthz", "/readyz":
+		handleProbe(resp, req)
+		return
+	case "/calibration":
+		handleCalibration(resp, req)
+		return
+	case "/dag":
+		handleDAG(resp, req)
+		return
+	case "/batch":
+		handleBatch(resp, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/tasks/") {
+		handleTask(resp, req)
+		return
+	}
+	reply := ""
+	if isEvent(req) {
+		params, typ, err := eventParams(req)
 		if err != nil {
-			http.Error(resp, "bad 'tb' parameter", 400)
+			rejectParams(resp, req, err)
 			return
 		}
+		req, reply = queryRequest(req, params), typ
 	}
-	ts0 := time.Now()
-	if ts > 0 {
-		time.Sleep(time.Duration(ts))
+	ctx, span := startRequestSpan(req, "simtask.request")
+	defer span.End()
+	_, _ = cpu.Percent(0, true)
+	_, _ = disk.IOCounters()
+	rt0 := time.Now()
+	params := req.URL.Query()
+	rlog := requestLogger(req, params)
+	if !params.Has("cl") {
+		resp.WriteHeader(200)
+		_, _ = resp.Write([]byte(""))
+		return
 	}
-	tb0 := time.Now()
-	rit := int64(0)
-	for ; rit < it || time.Now().Sub(tb0).Nanoseconds() < tb; rit++ {
+	cold := false
+	coldStart.Do(func() {
+		coldStarts.Inc()
+		cold = true
+	})
+	sim, err := parseSimulation(req, params)
+	if err != nil {
+		rejectParams(resp, req, err)
+		return
 	}
-	rtb := time.Now().Sub(tb0)
-	rts := tb0.Sub(ts0)
-	rtf := time.Now()
-	rdt := rtf.Sub(rt0)
-
-	res := map[string]any{}
-	res["rt0"] = strconv.FormatInt(rt0.UnixNano(), 10)
-	res["rtb"] = strconv.FormatInt(rtb.Nanoseconds(), 10)
-	res["rit"] = strconv.FormatInt(rit, 10)
-	res["rts"] = strconv.FormatInt(rts.Nanoseconds(), 10)
-	res["rdt"] = strconv.FormatInt(rdt.Nanoseconds(), 10)
-	res["rtf"] = strconv.FormatInt(rtf.UnixNano(), 10)
-
-	times, err := cpu.Times(true)
-	if err == nil {
-		res["cpu_times"] = times
+	format, err := negotiate(req.Header.Get("Accept"))
+	if err != nil && sim.version != 1 {
+		problemError(resp, req, err.Error(), 406, nil)
+		return
 	}
-	cpupc, err := cpu.Percent(0, true)
-	if err == nil {
-		res["cpu_pc"] = cpupc
+	if sim.custom != nil {
+		rlog = rlog.With("custom", sim.custom)
 	}
-	iouse, err := disk.IOCounters("/dev/xvda1", "/dev/xvda2", "/dev/sda") //sda for local tests
-	if err == nil {
-		res["iouse"] = iouse
+	if sim.profile != "" {
+		rlog = rlog.With("profile", sim.profile)
+		span.SetAttributes(attribute.String("simtask.profile", sim.profile))
 	}
-	memstat, err := mem.VirtualMemory()
-	if err == nil {
-		res["memstat"] = memstat
+	if sim.async {
+		submitTask(ctx, resp, req, sim, rt0, cold, rlog)
+		return
 	}
-	memexstat, err := mem.VirtualMemory()
-	if err == nil {
-		res["memexstat"] = memexstat
+	rlog.Debug("request started")
+	res, host, err := sim.run(ctx, rt0, cold)
+	if err != nil {
+		httpError(resp, req, err.Error(), 500)
+		return
 	}
-	avgstat, err := load.Avg()
-	if err == nil {
-		res["load"] = avgstat
+	var r []byte
+	if reply != "" {
+		r, err = json.Marshal(res)
+		resp.Header().Add("Content-Type", "application/json")
+		setReplyHeaders(resp.Header(), reply, res)
+	} else if sim.version == 1 {
+		r, err = json.Marshal(newResponseV1(res, host))
+		resp.Header().Add("Content-Type", "plain/text")
+	} else {
+		var ct string
+		r, ct, err = format.encode(res)
+		resp.Header().Add("Content-Type", ct)
+		resp.Header().Add("Vary", "Accept")
 	}
-	miscstat, err := load.Avg()
-	if err == nil {
-		res["miscstat"] = miscstat
+	if err != nil {
+		httpError(resp, req, err.Error(), 500)
+		return
 	}
-	proc, err := process.NewProcess(int32(unix.Getpid()))
-	psconn, err := proc.Connections()
-	if err == nil {
-		res["psconn"] = psconn
+
+	resp.Header().Add("X-Request-ID", params.Get("id"))
+	resp.Header().Add("Version", Version)
+	status := 200
+	transport := ""
+	timing := timingGroup(res)
+	if sim.fired {
+		rlog.Warn("injecting fault", "fault", sim.fault.Mode, "code", sim.fault.code(), timing)
+		faultsTotal.WithLabelValues(sim.fault.Mode).Inc()
+		if sim.fault.inject(req) {
+			rlog.Info("request", "status", 0, timing, "error", req.Context().Err())
+			return
+		}
+		if sim.fault.Mode == FaultStatus {
+			status = sim.fault.code()
+		} else {
+			transport = sim.fault.Mode
+		}
 	}
-	psio, err := proc.IOCounters()
-	if err == nil {
-		res["psio"] = psio
+	err = sim.shaping.respond(req.Context(), resp, status, r, transport)
+	if err != nil {
+		rlog.Info("request", "status", status, timing, "error", err)
+		return
 	}
-	psmem, err := proc.MemoryInfo()
-	if err == nil {
-		res["psmem"] = psmem
+	rlog.Info("request", "status", status, timing)
+}
+
+// queryRequest returns a copy of req as a GET with params in its query, for
+// tasks given in the body of a request rather than in its query.
+func queryRequest(req *http.Request, params url.Values) *http.Request {
+	q := req.Clone(req.Context())
+	q.Method, q.Body = http.MethodGet, http.NoBody
+	q.URL.RawQuery = params.Encode()
+	return q
+}
+
+// simulation is a checked simulation request: the task it runs and how to
+// respond with its result.
+type simulation struct {
+	version  int
+	fault    Fault
+	fired    bool
+	shaping  Shaping
+	task     workload.Task
+	custom   *api.Custom
+	async    bool
+	callback string
+	profile  string
+}
+
+// parseSimulation checks every parameter of a simulation request, so that a
+// rejection lists all the invalid ones, and builds its task.
+func parseSimulation(req *http.Request, params url.Values) (simulation, error) {
+	profile, perr := profiles.match(params)
+	var defCalls *workload.CallStage
+	if profile != nil {
+		params, defCalls = profile.apply(params), profile.Calls
 	}
-	pstimes, err := proc.Times()
-	if err == nil {
-		res["pstimes"] = pstimes
+	var (
+		version, verr   = parseResponseVersion(params)
+		terr            = limits.checkTargets(params)
+		bs, bserr       = parseBusyPolicy(params, workload.StopAll)
+		fault, ferr     = parseFault(params, instanceFault)
+		shaping, serr   = parseShaping(params, instanceShaping)
+		rng, rerr       = requestRand(params)
+		tail, lerr      = parseTail(params, instanceTail)
+		im, imerr       = parseIdle(params, instanceIdle)
+		bk, bkerr       = parseKernel(params, instanceKernel)
+		calls, cerr     = parseCalls(req, params, defCalls)
+		ncalls, depth   = calls.Tree()
+		kerr            = limits.checkKnobs(params, ncalls, depth)
+		async, cb, aerr = parseAsync(req, params)
+	)
+	if err := errors.Join(perr, verr, terr, bserr, ferr, serr, rerr, lerr, imerr, bkerr, cerr, kerr, aerr); err != nil {
+		return simulation{}, err
 	}
-	pscpupc, err := proc.CPUPercent()
-	if err == nil {
-		res["pscpupc"] = pscpupc
+	idleMax, busyMax := limits.stageMax()
+	busy := workload.Stage{Kind: workload.StageBusy, Kernel: bk, Policy: bs, Max: busyMax,
+		Duration: workload.Spec(params.Get("tb")), CPU: workload.Spec(params.Get("tc"))}
+	if params.Has("it") {
+		busy.Iterations = workload.Spec(params.Get("it"))
+	} else if params.Has("tw") {
+		busy.Work = workload.Spec(params.Get("tw"))
 	}
-	psmempc, err := proc.MemoryPercent()
-	if err == nil {
-		res["psmempc"] = psmempc
+	task := workload.Task{
+		Origin: requestOrigin(req, params),
+		Stages: []workload.Stage{{Kind: workload.StageIdle, Duration: workload.Spec(params.Get("ts")), Method: im, Max: idleMax}, busy},
+		Tail:   &tail,
+		Rand:   rng,
 	}
-	pccreatets, err := proc.CreateTime()
-	if err == nil {
-		res["pccreatets"] = pccreatets
+	if limits.CallFanout > 0 && (calls.Fanout == 0 || calls.Fanout > limits.CallFanout) {
+		calls.Fanout = limits.CallFanout
 	}
-	psctxsw, err := proc.NumCtxSwitches()
-	if err == nil {
-		res["psctxsw"] = psctxsw
+	if len(calls.Calls) > 0 {
+		task.Stages = append(task.Stages, workload.Stage{Kind: workload.StageCall, Calls: &calls})
 	}
-	psnfd, err := proc.NumFDs()
-	if err == nil {
-		res["psnfd"] = psnfd
+	sim := simulation{
+		version:  version,
+		fault:    fault,
+		fired:    fault.Fires(rng),
+		shaping:  shaping,
+		task:     task,
+		custom:   requestCustom(req, params),
+		async:    async,
+		callback: cb,
 	}
-	psth, err := proc.Threads()
-	if err == nil {
-		res["psth"] = psth
+	if profile != nil {
+		sim.profile = profile.Name
 	}
+	return sim, nil
+}
 
-	r, err := json.Marshal(res)
+// run executes the task of s, which started at rt0, and returns its
+// response and the host metrics sampled after it.
+func (s simulation) run(ctx context.Context, rt0 time.Time, cold bool) (api.Response, hostMetrics, error) {
+	result, err := workload.Execute(ctx, s.task)
 	if err != nil {
-		http.Error(resp, err.Error(), 500)
-		return
+		return api.Response{}, hostMetrics{}, err
 	}
-
-	resp.Header().Add("Content-Type", "plain/text")
-	resp.Header().Add("X-Request-ID", params.Get("id"))
-	resp.Header().Add("Version", Version)
-	resp.WriteHeader(200)
-	_, err = fmt.Fprintf(resp, string(r))
-	if err != nil {
-		http.Error(resp, err.Error(), 500)
-		return
+	rtf := time.Now()
+	res := newResponse(s.task, result, rt0, rtf, cold)
+	res.Custom = s.custom
+	durationSeconds.WithLabelValues("rdt").Observe(time.Duration(res.Timing.Total).Seconds())
+	durationSeconds.WithLabelValues("rts").Observe(time.Duration(res.Timing.Idle).Seconds())
+	durationSeconds.WithLabelValues("rtb").Observe(time.Duration(res.Timing.Busy).Seconds())
+	durationSeconds.WithLabelValues("rcl").Observe(time.Duration(res.Timing.Call).Seconds())
+	span := trace.SpanFromContext(ctx)
+	if s.fired {
+		res.Fault = &api.Fault{Mode: s.fault.Mode, Code: s.fault.code()}
+		span.SetAttributes(attribute.String("simtask.fault", s.fault.Mode))
+	}
+	if sc := span.SpanContext(); sc.IsValid() {
+		res.TraceID = sc.TraceID().String()
+		res.SpanID = sc.SpanID().String()
 	}
+	_, mspan := tracer.Start(ctx, "metrics")
+	host := collectHostMetrics()
+	res.Metrics = host.v2()
+	mspan.End()
+	return res, host, nil
 }
@@ -2,9 +2,13 @@ package function
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"function/api"
 )
 
 // TestHandle ensures that Handle executes without error and returns the
@@ -24,3 +28,20 @@ func TestHandle(t *testing.T) {
 		t.Fatalf("unexpected response code: %v", res.StatusCode)
 	}
 }
+
+// TestHandleSampledSeed ensures that the same seed samples the same targets.
+func TestHandleSampledSeed(t *testing.T) {
+	targets := func() string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://example.com/?cl=1&seed=42&ts=exp:1000&it=uniform:10,1000", nil)
+		Handle(context.Background(), w, req)
+		var body api.Response
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(body.Stages[0].Sampled, body.Stages[1].Sampled)
+	}
+	if a, b := targets(), targets(); a != b {
+		t.Fatalf("seeded targets differ: %s and %s", a, b)
+	}
+}
@@ -0,0 +1,149 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Probe modes accepted by the mode parameter of the health endpoints.
+const (
+	ProbeOK   = "ok"   // always succeed
+	ProbeFail = "fail" // always fail
+	ProbeFlap = "flap" // alternate between success and failure every Period
+)
+
+// Probe is the behaviour of a health endpoint. Every probe is answered
+// after Delay, successes with 200 and failures with Code.
+type Probe struct {
+	Mode   string        `json:"mode"`
+	Delay  time.Duration `json:"delay"`
+	Period time.Duration `json:"period"`
+	Code   int           `json:"code"`
+}
+
+// instanceStart is the time flapping probes count their periods from.
+var instanceStart = time.Now()
+
+// probes holds the behaviour of /healthz and /readyz. They start from
+// SIMTASK_HEALTHZ and SIMTASK_READYZ, query strings such as
+// "mode=flap&period=10s", and are changed by POSTs to the
+// endpoints with the same parameters.
+var probes = struct {
+	sync.Mutex
+	m map[string]Probe
+}{m: map[string]Probe{
+	"/healthz": probeFromEnv("SIMTASK_HEALTHZ"),
+	"/readyz":  probeFromEnv("SIMTASK_READYZ"),
+}}
+
+func probeFromEnv(env string) Probe {
+	def := Probe{Mode: ProbeOK, Code: http.StatusServiceUnavailable}
+	params, err := url.ParseQuery(os.Getenv(env))
+	if err == nil {
+		var p Probe
+		if p, err = parseProbe(params, def); err == nil {
+			return p
+		}
+	}
+	logger.Warn("ignoring bad "+env, "error", err)
+	return def
+}
+
+// parseProbe overrides def with the mode, delay, period and code
+// parameters, durations in ns or with a unit.
+func parseProbe(params url.Values, def Probe) (Probe, error) {
+	p := def
+	var errs []error
+	if params.Has("mode") {
+		p.Mode = params.Get("mode")
+		switch p.Mode {
+		case ProbeOK, ProbeFail, ProbeFlap:
+		default:
+			errs = append(errs, badParam("mode", "must be ok, fail or flap"))
+		}
+	}
+	for _, d := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"delay", &p.Delay}, {"period", &p.Period}} {
+		if params.Has(d.name) {
+			v, err := parseDuration(params.Get(d.name))
+			if err != nil {
+				errs = append(errs, badParam(d.name, err.Error()))
+				continue
+			}
+			*d.dst = v
+		}
+	}
+	if params.Has("code") {
+		code, err := strconv.Atoi(params.Get("code"))
+		if err != nil || code < 100 || code > 599 {
+			errs = append(errs, badParam("code", "must be an HTTP status"))
+		} else {
+			p.Code = code
+		}
+	}
+	if p.Mode == ProbeFlap && p.Period <= 0 {
+		errs = append(errs, badParam("period", "required by flap"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Probe{}, err
+	}
+	return p, nil
+}
+
+// healthy reports whether the probe succeeds at t.
+func (p Probe) healthy(t time.Time) bool {
+	switch p.Mode {
+	case ProbeFail:
+		return false
+	case ProbeFlap:
+		return (t.Sub(instanceStart)/p.Period)%2 == 0
+	}
+	return true
+}
+
+// handleProbe answers GETs to a health endpoint according to its probe and
+// reconfigures it on POST.
+func handleProbe(resp http.ResponseWriter, req *http.Request) {
+	probes.Lock()
+	p := probes.m[req.URL.Path]
+	probes.Unlock()
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if err := sleepCtx(req.Context(), p.Delay); err != nil {
+			return
+		}
+		resp.Header().Add("Version", Version)
+		if !p.healthy(time.Now()) {
+			http.Error(resp, "unhealthy", p.Code)
+			return
+		}
+		resp.WriteHeader(200)
+		_, _ = resp.Write([]byte("ok\n"))
+	case http.MethodPost:
+		p, err := parseProbe(req.URL.Query(), p)
+		if err != nil {
+			rejectParams(resp, req, err)
+			return
+		}
+		probes.Lock()
+		probes.m[req.URL.Path] = p
+		probes.Unlock()
+		logger.Info("probe changed", "path", req.URL.Path, "mode", p.Mode, "delay", p.Delay, "period", p.Period, "code", p.Code)
+		r, _ := json.Marshal(p)
+		resp.Header().Add("Content-Type", "application/json")
+		resp.Header().Add("Version", Version)
+		resp.WriteHeader(200)
+		_, _ = resp.Write(r)
+	default:
+		httpError(resp, req, fmt.Sprintf("method %s not allowed", req.Method), 405)
+	}
+}
@@ -0,0 +1,49 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHandleProbe ensures that a health endpoint can be made to fail and
+// flap through its admin call.
+func TestHandleProbe(t *testing.T) {
+	probe := func(method, target string) int {
+		w := httptest.NewRecorder()
+		Handle(context.Background(), w, httptest.NewRequest(method, "http://example.com"+target, nil))
+		return w.Code
+	}
+	defer probe("POST", "/readyz?mode=ok&delay=0")
+
+	if code := probe("GET", "/readyz"); code != 200 {
+		t.Fatalf("unexpected default probe code: %v", code)
+	}
+	if code := probe("POST", "/readyz?mode=fail&code=500"); code != 200 {
+		t.Fatalf("unexpected admin call code: %v", code)
+	}
+	if code := probe("GET", "/readyz"); code != 500 {
+		t.Fatalf("unexpected failing probe code: %v", code)
+	}
+	if code := probe("GET", "/healthz"); code != 200 {
+		t.Fatalf("liveness changed with readiness: %v", code)
+	}
+	for _, q := range []string{"mode=flap", "mode=flap&period=x&code=99", "delay=-1s"} {
+		w := httptest.NewRecorder()
+		Handle(context.Background(), w, httptest.NewRequest("POST", "http://example.com/readyz?"+q, nil))
+		var p problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != 400 || len(p.InvalidParams) == 0 {
+			t.Errorf("%s: unexpected response: %v %s", q, w.Code, w.Body.String())
+		}
+	}
+	if code := probe("POST", "/readyz?mode=ok&delay=1ms"); code != 200 {
+		t.Fatalf("delay with a unit rejected: %v", code)
+	}
+
+	p := Probe{Mode: ProbeFlap, Period: time.Second}
+	if !p.healthy(instanceStart) || p.healthy(instanceStart.Add(1500*time.Millisecond)) {
+		t.Fatal("flapping probe does not alternate")
+	}
+}
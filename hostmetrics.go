@@ -0,0 +1,117 @@
+package function
+
+import (
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+	"golang.org/x/sys/unix"
+
+	"function/api"
+)
+
+// hostMetrics is the state of the node and of this process reported with
+// every response. Metrics that cannot be read are left out.
+type hostMetrics struct {
+	CPUTimes   []cpu.TimesStat                `json:"cpu_times,omitempty"`
+	CPUPercent []float64                      `json:"cpu_pc,omitempty"`
+	IOUse      map[string]disk.IOCountersStat `json:"iouse,omitempty"`
+	MemStat    *mem.VirtualMemoryStat         `json:"memstat,omitempty"`
+	MemExStat  *mem.VirtualMemoryStat         `json:"memexstat,omitempty"`
+	Load       *load.AvgStat                  `json:"load,omitempty"`
+	MiscStat   *load.AvgStat                  `json:"miscstat,omitempty"`
+	PsConn     []net.ConnectionStat           `json:"psconn,omitempty"`
+	PsIO       *process.IOCountersStat        `json:"psio,omitempty"`
+	PsMem      *process.MemoryInfoStat        `json:"psmem,omitempty"`
+	PsTimes    *cpu.TimesStat                 `json:"pstimes,omitempty"`
+	PsCPUPc    *float64                       `json:"pscpupc,omitempty"`
+	PsMemPc    *float32                       `json:"psmempc,omitempty"`
+	PcCreateTs *int64                         `json:"pccreatets,omitempty"`
+	PsCtxSw    *process.NumCtxSwitchesStat    `json:"psctxsw,omitempty"`
+	PsNFD      *int32                         `json:"psnfd,omitempty"`
+	PsTh       map[int32]*cpu.TimesStat       `json:"psth,omitempty"`
+}
+
+// collectHostMetrics reads the host metrics.
+func collectHostMetrics() hostMetrics {
+	var m hostMetrics
+	m.CPUTimes, _ = cpu.Times(true)
+	m.CPUPercent, _ = cpu.Percent(0, true)
+	m.IOUse, _ = disk.IOCounters("/dev/xvda1", "/dev/xvda2", "/dev/sda") //sda for local tests
+	m.MemStat, _ = mem.VirtualMemory()
+	m.MemExStat, _ = mem.VirtualMemory()
+	m.Load, _ = load.Avg()
+	m.MiscStat, _ = load.Avg()
+	proc, err := process.NewProcess(int32(unix.Getpid()))
+	if err != nil {
+		return m
+	}
+	m.PsConn, _ = proc.Connections()
+	m.PsIO, _ = proc.IOCounters()
+	m.PsMem, _ = proc.MemoryInfo()
+	m.PsTimes, _ = proc.Times()
+	if v, err := proc.CPUPercent(); err == nil {
+		m.PsCPUPc = &v
+	}
+	if v, err := proc.MemoryPercent(); err == nil {
+		m.PsMemPc = &v
+	}
+	if v, err := proc.CreateTime(); err == nil {
+		m.PcCreateTs = &v
+	}
+	m.PsCtxSw, _ = proc.NumCtxSwitches()
+	if v, err := proc.NumFDs(); err == nil {
+		m.PsNFD = &v
+	}
+	m.PsTh, _ = proc.Threads()
+	return m
+}
+
+// v2 summarises the host metrics in the typed form of the v2 response.
+func (m hostMetrics) v2() api.Metrics {
+	var h api.HostMetrics
+	h.CPUPercent = m.CPUPercent
+	if m.Load != nil {
+		h.Load1, h.Load5, h.Load15 = m.Load.Load1, m.Load.Load5, m.Load.Load15
+	}
+	if m.MemStat != nil {
+		h.MemTotal, h.MemAvailable, h.MemUsed = m.MemStat.Total, m.MemStat.Available, m.MemStat.Used
+	}
+	for _, d := range m.IOUse {
+		h.DiskReads += d.ReadCount
+		h.DiskWrites += d.WriteCount
+		h.DiskRead += d.ReadBytes
+		h.DiskWritten += d.WriteBytes
+	}
+	p := api.ProcessMetrics{Threads: len(m.PsTh), Connections: len(m.PsConn)}
+	if m.PcCreateTs != nil {
+		p.Start = *m.PcCreateTs * int64(time.Millisecond)
+	}
+	if m.PsTimes != nil {
+		p.CPUUser = int64(m.PsTimes.User * float64(time.Second))
+		p.CPUSystem = int64(m.PsTimes.System * float64(time.Second))
+	}
+	if m.PsCPUPc != nil {
+		p.CPUPercent = *m.PsCPUPc
+	}
+	if m.PsMem != nil {
+		p.RSS, p.VMS = m.PsMem.RSS, m.PsMem.VMS
+	}
+	if m.PsMemPc != nil {
+		p.MemPercent = float64(*m.PsMemPc)
+	}
+	if m.PsCtxSw != nil {
+		p.VoluntarySwitches, p.InvoluntarySwitches = m.PsCtxSw.Voluntary, m.PsCtxSw.Involuntary
+	}
+	if m.PsNFD != nil {
+		p.FDs = int(*m.PsNFD)
+	}
+	if m.PsIO != nil {
+		p.ReadBytes, p.WriteBytes = m.PsIO.ReadBytes, m.PsIO.WriteBytes
+	}
+	return api.Metrics{Host: h, Process: p}
+}
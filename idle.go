@@ -0,0 +1,38 @@
+package function
+
+import (
+	"fmt"
+	"net/url"
+
+	"function/workload"
+)
+
+// instanceIdle is the idle method used by requests without an im parameter.
+var instanceIdle = idleFromEnv()
+
+func idleFromEnv() string {
+	m, err := parseIdle(envParams(map[string]string{"SIMTASK_IDLE": "im"}), workload.IdleSleep)
+	if err != nil {
+		logger.Warn("ignoring instance idle method", "error", err)
+		return workload.IdleSleep
+	}
+	return m
+}
+
+// parseIdle overrides def with the im parameter.
+func parseIdle(params url.Values, def string) (string, error) {
+	if !params.Has("im") {
+		return def, nil
+	}
+	m := params.Get("im")
+	switch m {
+	case workload.IdleSleep, workload.IdleTimerSpin, workload.IdleSpin:
+	case workload.IdleNanosleep, workload.IdleTimerfd:
+		if !workload.IdleSyscalls {
+			return "", badParam("im", fmt.Sprintf("%q not supported on this platform", m))
+		}
+	default:
+		return "", badParam("im", "unknown idle method")
+	}
+	return m, nil
+}
@@ -0,0 +1,72 @@
+package function
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// instanceID identifies this function instance in logs and responses: the
+// host name, which is the pod name under Kubernetes, and a random suffix
+// that tells restarted containers apart.
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return host + "-" + hex.EncodeToString(b[:])
+}
+
+// revision is the Knative revision serving this instance, if any.
+var revision = os.Getenv("K_REVISION")
+
+// logger writes JSON lines to stderr. SIMTASK_LOG_LEVEL sets the minimum
+// level: debug, info (the default), warn or error.
+var logger = newLogger()
+
+func newLogger() *slog.Logger {
+	var level slog.Level
+	if v, ok := os.LookupEnv("SIMTASK_LOG_LEVEL"); ok {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			level = slog.LevelInfo
+		}
+	}
+	attrs := []any{"instance", instanceID, "version", Version}
+	if revision != "" {
+		attrs = append(attrs, "revision", revision)
+	}
+	h := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	return slog.New(h).With(attrs...)
+}
+
+// experiment returns the experiment tag of a request: the exp parameter, or
+// the X-Experiment header.
+func experiment(req *http.Request, params url.Values) string {
+	if params.Has("exp") {
+		return params.Get("exp")
+	}
+	return req.Header.Get("X-Experiment")
+}
+
+// requestLogger returns logger with the identifiers of the request, so
+// that its lines can be joined with client results.
+func requestLogger(req *http.Request, params url.Values) *slog.Logger {
+	return logger.With(
+		"cl", params.Get("cl"),
+		"id", params.Get("id"),
+		"t0", params.Get("t0"),
+		"exp", experiment(req, params),
+		"path", req.URL.Path,
+	)
+}
+
+// httpError logs a failed request and replies with msg and code.
+func httpError(resp http.ResponseWriter, req *http.Request, msg string, code int) {
+	requestLogger(req, req.URL.Query()).Warn("request failed", "status", code, "error", strings.TrimSpace(msg))
+	http.Error(resp, msg, code)
+}
@@ -0,0 +1,37 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHandleLog ensures that a request is logged as a JSON line with its
+// identifiers, experiment tag and timings.
+func TestHandleLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *slog.Logger) { logger = l }(logger)
+	logger = slog.New(slog.NewJSONHandler(&buf, nil)).With("instance", instanceID)
+
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=c1&id=r1&t0=5&ts=0&tb=0&exp=e1", nil))
+
+	var line struct {
+		Msg      string
+		Instance string
+		Cl, ID   string
+		T0, Exp  string
+		Status   int
+		Timing   map[string]any
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if line.Msg != "request" || line.Instance != instanceID || line.Cl != "c1" || line.ID != "r1" ||
+		line.T0 != "5" || line.Exp != "e1" || line.Status != 200 || line.Timing["rdt"] == nil {
+		t.Fatalf("unexpected log line: %s", buf.String())
+	}
+}
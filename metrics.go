@@ -0,0 +1,148 @@
+package function
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registry holds the instance metrics served on /metrics, next to the Go
+// runtime and process collectors.
+var registry = prometheus.NewRegistry()
+
+// stageBuckets spans 100 µs to about 100 s.
+var stageBuckets = prometheus.ExponentialBuckets(1e-4, 2, 21)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "simtask_requests_total",
+		Help: "Requests served, by route, status code and function id.",
+	}, []string{"route", "code", "function_id"})
+	inFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "simtask_in_flight_requests",
+		Help: "Requests being served.",
+	})
+	coldStarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "simtask_cold_starts_total",
+		Help: "Simulation requests that were the first of their instance.",
+	})
+	faultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "simtask_faults_injected_total",
+		Help: "Faults injected, by mode.",
+	}, []string{"mode"})
+	rejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "simtask_rejected_requests_total",
+		Help: "Simulation requests rejected, by invalid parameter and cause (invalid or limit), once for each invalid parameter.",
+	}, []string{"param", "cause"})
+	unauthenticated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "simtask_unauthenticated_requests_total",
+		Help: "Requests rejected for lack of valid credentials.",
+	})
+	asyncTasks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "simtask_async_tasks",
+		Help: "Asynchronous tasks retained, by status.",
+	}, []string{"status"})
+	durationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "simtask_duration_seconds",
+		Help:    "Real duration of the whole simulated task (rdt) and of its idle (rts), busy (rtb) and call (rcl) stages.",
+		Buckets: stageBuckets,
+	}, []string{"stage"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal, inFlight, coldStarts, faultsTotal, rejectedTotal, unauthenticated, asyncTasks, durationSeconds,
+	)
+}
+
+var metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+
+// coldStart counts the first simulation request of the instance.
+var coldStart sync.Once
+
+// functionID is the function this instance serves, labelling its requests:
+// SIMTASK_FUNCTION_ID or else K_SERVICE, the name of its Knative service. It
+// is never taken from requests, so that clients cannot add label values.
+var functionID = functionIDFromEnv()
+
+func functionIDFromEnv() string {
+	if id := os.Getenv("SIMTASK_FUNCTION_ID"); id != "" {
+		return id
+	}
+	return os.Getenv("K_SERVICE")
+}
+
+// route returns the endpoint a request was served by, keeping the label
+// values of arbitrary paths bounded.
+func route(req *http.Request) string {
+	switch req.URL.Path {
+	case "/batch", "/calibration", "/dag", "/healthz", "/readyz", "/schema", "/schema.proto":
+		return req.URL.Path
+	}
+	if strings.HasPrefix(req.URL.Path, "/tasks/") {
+		return "/tasks"
+	}
+	return "/"
+}
+
+// statusRecorder remembers the status code written through it. It keeps
+// the Flusher and Hijacker of the underlying writer available to the
+// transport faults.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if fl, ok := r.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hj.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument counts a request in flight and returns the writer to serve it
+// through and the function that records its outcome. Requests that end
+// without a status, such as reset or hung ones, count with code 0.
+func instrument(resp http.ResponseWriter, req *http.Request) (*statusRecorder, func()) {
+	inFlight.Inc()
+	rec := &statusRecorder{ResponseWriter: resp}
+	return rec, func() {
+		inFlight.Dec()
+		requestsTotal.WithLabelValues(route(req), strconv.Itoa(rec.code), functionID).Inc()
+	}
+}
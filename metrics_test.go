@@ -0,0 +1,42 @@
+package function
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestHandleMetrics ensures that served requests show up on /metrics.
+func TestHandleMetrics(t *testing.T) {
+	defer func(id string) { functionID = id }(functionID)
+	functionID = "fmetrics"
+	served := requestsTotal.WithLabelValues("/", "503", "fmetrics")
+	before := testutil.ToFloat64(served)
+	req := httptest.NewRequest("GET", "http://other.default.knative.dev/?cl=1&ts=0&tb=0&fm=status&fc=503", nil)
+	Handle(context.Background(), httptest.NewRecorder(), req)
+
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/metrics", nil))
+	if w.Code != 200 {
+		t.Fatalf("unexpected response code: %v", w.Code)
+	}
+	if n := testutil.ToFloat64(served) - before; n != 1 {
+		t.Errorf("%v requests counted, want 1", n)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`simtask_requests_total{code="503",function_id="fmetrics",route="/"}`,
+		`simtask_faults_injected_total{mode="status"}`,
+		`simtask_duration_seconds_bucket{stage="rdt"`,
+		`simtask_cold_starts_total 1`,
+		`simtask_in_flight_requests 0`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
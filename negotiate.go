@@ -0,0 +1,105 @@
+package function
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"function/api"
+)
+
+// Response formats selected by the Accept header of simulation requests.
+const (
+	FormatJSON     = "application/json"
+	FormatCSV      = "text/csv"
+	FormatMsgpack  = "application/msgpack"
+	FormatProtobuf = "application/protobuf"
+)
+
+// formats maps the accepted media types to the format they select.
+var formats = map[string]string{
+	"application/json":        FormatJSON,
+	"text/csv":                FormatCSV,
+	"application/msgpack":     FormatMsgpack,
+	"application/x-msgpack":   FormatMsgpack,
+	"application/vnd.msgpack": FormatMsgpack,
+	"application/protobuf":    FormatProtobuf,
+	"application/x-protobuf":  FormatProtobuf,
+}
+
+// Format is a negotiated response format. Header is false when CSV was
+// requested with header=absent.
+type Format struct {
+	Type   string
+	Header bool
+}
+
+// negotiate picks the format of the response from an Accept header: the
+// supported media type with the highest quality, the first listed on ties.
+// JSON answers a missing header and wildcards.
+func negotiate(accept string) (Format, error) {
+	if strings.TrimSpace(accept) == "" {
+		return Format{Type: FormatJSON}, nil
+	}
+	best, bestQ := Format{}, 0.0
+	for _, r := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(r)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		f := Format{Type: formats[mt], Header: params["header"] != "absent"}
+		if mt == "*/*" || mt == "application/*" {
+			f.Type = FormatJSON
+		}
+		if f.Type != "" && q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	if best.Type == "" {
+		return Format{}, fmt.Errorf("none of %q is available, use one of %s, %s, %s or %s",
+			accept, FormatJSON, FormatCSV, FormatMsgpack, FormatProtobuf)
+	}
+	return best, nil
+}
+
+// encode renders res in the format and returns it with its content type.
+func (f Format) encode(res api.Response) ([]byte, string, error) {
+	switch f.Type {
+	case FormatCSV:
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		if f.Header {
+			_ = w.Write(api.CSVHeader())
+		}
+		_ = w.Write(res.CSVRecord())
+		w.Flush()
+		header := "present"
+		if !f.Header {
+			header = "absent"
+		}
+		return b.Bytes(), FormatCSV + "; header=" + header, w.Error()
+	case FormatMsgpack:
+		var b bytes.Buffer
+		enc := msgpack.NewEncoder(&b)
+		enc.SetCustomStructTag("json")
+		enc.UseCompactInts(true)
+		err := enc.Encode(res)
+		return b.Bytes(), FormatMsgpack, err
+	case FormatProtobuf:
+		return res.MarshalProto(), FormatProtobuf + "; proto=simtask.v2.Response", nil
+	}
+	b, err := json.Marshal(res)
+	return b, FormatJSON, err
+}
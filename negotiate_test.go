@@ -0,0 +1,85 @@
+package function
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"function/api"
+)
+
+// TestNegotiate ensures that the supported media type with the highest
+// quality wins and that unsupported ones are refused.
+func TestNegotiate(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                    FormatJSON,
+		"*/*":                                 FormatJSON,
+		"text/csv":                            FormatCSV,
+		"application/x-msgpack, */*;q=0.1":    FormatMsgpack,
+		"text/html, application/x-protobuf":   FormatProtobuf,
+		"text/csv;q=0.5, application/msgpack": FormatMsgpack,
+	} {
+		f, err := negotiate(accept)
+		if err != nil || f.Type != want {
+			t.Errorf("%q: got %q, %v, want %q", accept, f.Type, err, want)
+		}
+	}
+	if _, err := negotiate("text/html, application/json;q=0"); err == nil {
+		t.Error("expected an error for unsupported types")
+	}
+}
+
+// TestHandleFormats ensures that every format carries the same timings.
+func TestHandleFormats(t *testing.T) {
+	get := func(accept string) (*httptest.ResponseRecorder, []byte) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://example.com/?cl=1&id=f&ts=0&it=1000", nil)
+		req.Header.Set("Accept", accept)
+		Handle(context.Background(), w, req)
+		return w, w.Body.Bytes()
+	}
+
+	w, b := get("application/msgpack")
+	var mp api.Response
+	dec := msgpack.NewDecoder(strings.NewReader(string(b)))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&mp); err != nil || w.Header().Get("Content-Type") != FormatMsgpack {
+		t.Fatalf("msgpack: %v %q", err, w.Header().Get("Content-Type"))
+	}
+	if mp.ID != "f" || mp.Stages[1].Iterations != 1000 || mp.Timing.Total <= 0 {
+		t.Errorf("msgpack: unexpected response %+v", mp)
+	}
+
+	_, b = get("application/protobuf")
+	var pb api.Response
+	if err := pb.UnmarshalProto(b); err != nil {
+		t.Fatal(err)
+	}
+	if pb.ID != "f" || pb.Stages[1].Iterations != 1000 || pb.Timing.Total <= 0 || pb.Instance.ID != instanceID {
+		t.Errorf("protobuf: unexpected response %+v", pb)
+	}
+
+	w, b = get("text/csv")
+	rows, err := csv.NewReader(strings.NewReader(string(b))).ReadAll()
+	if err != nil || len(rows) != 2 || len(rows[0]) != len(rows[1]) {
+		t.Fatalf("csv: %v %q", err, b)
+	}
+	row := map[string]string{}
+	for i, k := range rows[0] {
+		row[k] = rows[1][i]
+	}
+	if row["id"] != "f" || row["iterations"] != "1000" || row["total"] == "" {
+		t.Errorf("csv: unexpected row %v", row)
+	}
+	if _, b = get("text/csv; header=absent"); strings.Count(string(b), "\n") != 1 {
+		t.Errorf("csv: unexpected header in %q", b)
+	}
+
+	if w, _ = get("image/png"); w.Code != 406 {
+		t.Errorf("unexpected response code for an unsupported type: %v", w.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+
+	"function/workload"
+)
+
+// Profile is the workload of the requests that match its keys: AppID,
+// FunctionID, EventType and RequestType, as in ServiceRequest, any value
+// matching the keys left out. Params are simulation parameters, defaults of
+// the ones of the request, and Calls the call stage of requests without
+// calls of their own.
+type Profile struct {
+	Name        string              `json:"name,omitempty"`
+	AppID       *uint16             `json:"app_id,omitempty"`
+	FunctionID  *uint16             `json:"function_id,omitempty"`
+	EventType   *uint8              `json:"event_type,omitempty"`
+	RequestType *uint8              `json:"request_type,omitempty"`
+	Params      map[string]string   `json:"params,omitempty"`
+	Calls       *workload.CallStage `json:"calls,omitempty"`
+}
+
+// profileTable is a list of profiles, the first of the most specific ones
+// matching a request applying to it.
+type profileTable []Profile
+
+// profiles applies to every simulation request of this instance. It is
+// read from the JSON array of profiles in SIMTASK_PROFILES, or in the file
+// SIMTASK_PROFILES_FILE, such as a mounted ConfigMap.
+var profiles = profilesFromEnv()
+
+func profilesFromEnv() profileTable {
+	b := []byte(os.Getenv("SIMTASK_PROFILES"))
+	if path := os.Getenv("SIMTASK_PROFILES_FILE"); path != "" {
+		var err error
+		if b, err = os.ReadFile(path); err != nil {
+			logger.Error("no profiles loaded", "path", path, "error", err)
+			return nil
+		}
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	t, err := parseProfiles(b)
+	if err != nil {
+		logger.Error("no profiles loaded", "error", err)
+		return nil
+	}
+	logger.Info("profiles loaded", "profiles", len(t))
+	return t
+}
+
+// parseProfiles reads a profile table, naming the unnamed profiles after
+// their index.
+func parseProfiles(b []byte) (profileTable, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var t profileTable
+	if err := dec.Decode(&t); err != nil {
+		return nil, err
+	}
+	for i := range t {
+		if t[i].Name == "" {
+			t[i].Name = strconv.Itoa(i)
+		}
+		if t[i].Calls != nil {
+			if err := t[i].Calls.Validate(); err != nil {
+				return nil, fmt.Errorf("profile %s: %w", t[i].Name, err)
+			}
+		}
+	}
+	return t, nil
+}
+
+// profileKeys are the parameters matched against the keys of the profiles,
+// with their size in bits.
+var profileKeys = []struct {
+	name string
+	bits int
+}{{"app_id", 16}, {"function_id", 16}, {"event_type", 8}, {"request_type", 8}}
+
+// match returns the profile of a request, the first of the ones matching
+// the most keys, or nil if none matches.
+func (t profileTable) match(params url.Values) (*Profile, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	keys := map[string]uint64{}
+	for _, k := range profileKeys {
+		if params.Has(k.name) {
+			v, err := strconv.ParseUint(params.Get(k.name), 10, k.bits)
+			if err != nil {
+				return nil, badParam(k.name, fmt.Sprintf("must be an integer from 0 to %d", uint64(1)<<k.bits-1))
+			}
+			keys[k.name] = v
+		}
+	}
+	var best *Profile
+	most := -1
+	for i := range t {
+		p := &t[i]
+		n, ok := 0, true
+		for j, key := range p.keys() {
+			if key == nil {
+				continue
+			}
+			v, has := keys[profileKeys[j].name]
+			if !has || v != *key {
+				ok = false
+				break
+			}
+			n++
+		}
+		if ok && n > most {
+			best, most = p, n
+		}
+	}
+	return best, nil
+}
+
+// keys returns the keys of the profile, in the order of profileKeys.
+func (p *Profile) keys() []*uint64 {
+	return []*uint64{widen(p.AppID), widen(p.FunctionID), widen(p.EventType), widen(p.RequestType)}
+}
+
+func widen[T uint8 | uint16](v *T) *uint64 {
+	if v == nil {
+		return nil
+	}
+	w := uint64(*v)
+	return &w
+}
+
+// apply returns the parameters of a request completed with the ones of the
+// profile.
+func (p *Profile) apply(params url.Values) url.Values {
+	q := url.Values{}
+	for k, v := range p.Params {
+		q.Set(k, v)
+	}
+	for k, v := range params {
+		q[k] = v
+	}
+	return q
+}
@@ -0,0 +1,80 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"function/api"
+)
+
+const testProfiles = `[
+	{"name": "default", "params": {"ts": "0", "tb": "1ms"}},
+	{"name": "uploads", "event_type": 2, "params": {"ts": "2ms", "tb": "0"}},
+	{"name": "thumbnail", "app_id": 7, "function_id": 3, "event_type": 2, "request_type": 1,
+	 "params": {"ts": "3ms", "tb": "0", "bk": "hash"}},
+	{"name": "shadowed", "app_id": 7, "function_id": 3, "event_type": 2, "request_type": 1, "params": {"ts": "9s"}}
+]`
+
+// TestHandleProfiles ensures that requests carrying trace identifiers are
+// expanded with the most specific matching profile.
+func TestHandleProfiles(t *testing.T) {
+	defer func(p profileTable) { profiles = p }(profiles)
+	var err error
+	if profiles, err = parseProfiles([]byte(testProfiles)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		query  string
+		idle   time.Duration
+		kernel string
+	}{
+		{"cl=c1&t0=5&app_id=7&function_id=3&event_type=2&request_type=1", 3 * time.Millisecond, "hash"},
+		{"cl=c1&app_id=7&function_id=3&event_type=2&request_type=1&ts=4ms", 4 * time.Millisecond, "hash"},
+		{"cl=c1&app_id=8&function_id=3&event_type=2&request_type=1", 2 * time.Millisecond, "loop"},
+		{"cl=c1&app_id=7", 0, "loop"},
+	} {
+		w := httptest.NewRecorder()
+		Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?"+tc.query, nil))
+		var res api.Response
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: %v: %s", tc.query, err, w.Body.String())
+		}
+		if idle, busy := stage(res, "idle"), stage(res, "busy"); idle.Target.Duration != int64(tc.idle) || busy.Kernel != tc.kernel {
+			t.Errorf("%s: unexpected stages: %+v %+v", tc.query, idle, busy)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=c1&event_type=300", nil))
+	if w.Code != 400 {
+		t.Errorf("bad key: unexpected response code %v", w.Code)
+	}
+
+	// A trace record without duration takes the targets of its profile.
+	header := map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "e1", "Ce-Source": "/trace", "Ce-Type": "t"}
+	_, res := postEvent(t, "", header, `{"ts": 1000, "app_id": 7, "function_id": 3, "event_type": 2, "request_type": 1}`)
+	if idle := stage(res, "idle"); idle.Target.Duration != int64(3*time.Millisecond) || res.T0 != "1000" {
+		t.Errorf("unexpected event response: %+v", res)
+	}
+}
+
+// TestParseProfiles ensures that malformed profile tables are refused.
+func TestParseProfiles(t *testing.T) {
+	for _, b := range []string{
+		`{"name": "x"}`,
+		`[{"name": "x", "app": 7}]`,
+		`[{"event_type": 256}]`,
+		`[{"calls": {"mode": "any", "calls": [{"url": "http://x"}]}}]`,
+	} {
+		if _, err := parseProfiles([]byte(b)); err == nil {
+			t.Errorf("%s: no error", b)
+		}
+	}
+	p, err := parseProfiles([]byte(`[{"params": {"tb": "1ms"}}, {"name": "x"}]`))
+	if err != nil || len(p) != 2 || p[0].Name != "0" || p[1].Name != "x" {
+		t.Errorf("unexpected profiles %+v: %v", p, err)
+	}
+}
@@ -0,0 +1,184 @@
+package function
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"function/api"
+	"function/workload"
+)
+
+// parseResponseVersion returns the response schema version selected by the
+// v parameter: 2, the default, or 1 for the original schema.
+func parseResponseVersion(params url.Values) (int, error) {
+	switch params.Get("v") {
+	case "", "2":
+		return api.Version, nil
+	case "1":
+		return 1, nil
+	}
+	return 0, badParam("v", "must be 1 or 2")
+}
+
+// newResponse renders the result of a task that Handle received at rt0 and
+// finished at rtf, leaving the fault, trace and metrics to the caller.
+func newResponse(task workload.Task, result workload.Result, rt0, rtf time.Time, cold bool) api.Response {
+	res := api.Response{
+		Version:    api.Version,
+		Client:     task.Client,
+		ID:         task.ID,
+		T0:         task.T0,
+		Experiment: task.Experiment,
+		Timing: api.Timing{
+			Start: rt0.UnixNano(),
+			End:   rtf.UnixNano(),
+			Total: rtf.Sub(rt0).Nanoseconds(),
+		},
+		Stages:   []api.Stage{},
+		Tail:     result.Tail,
+		Instance: api.Instance{ID: instanceID, Version: Version, Revision: revision, Cold: cold},
+	}
+	for _, s := range result.Stages {
+		st := api.Stage{
+			Kind:       s.Kind,
+			Start:      s.Start.UnixNano(),
+			Duration:   s.Duration.Nanoseconds(),
+			Sampled:    apiTargets(s.Sampled),
+			Target:     apiTargets(s.Target),
+			Method:     s.Method,
+			Kernel:     s.Kernel,
+			Iterations: s.Iterations,
+			CPU:        s.CPU.Nanoseconds(),
+			End:        s.End,
+			Calls:      s.Calls,
+		}
+		switch s.Kind {
+		case workload.StageIdle:
+			res.Timing.Idle += st.Duration
+			res.Timing.IdleOvershoot += st.Duration - st.Target.Duration
+		case workload.StageBusy:
+			res.Timing.Busy += st.Duration
+			st.Speed = workload.CurrentCalibration().Speed[s.Kernel]
+		case workload.StageCall:
+			res.Timing.Call += st.Duration
+		}
+		res.Stages = append(res.Stages, st)
+	}
+	return res
+}
+
+func apiTargets(t workload.Targets) api.Targets {
+	return api.Targets{Duration: t.Duration.Nanoseconds(), Iterations: t.Iterations, CPU: t.CPU.Nanoseconds()}
+}
+
+// stage returns the first stage of the given kind of res, or a zero stage.
+func stage(res api.Response, kind string) api.Stage {
+	for _, s := range res.Stages {
+		if s.Kind == kind {
+			return s
+		}
+	}
+	return api.Stage{}
+}
+
+// timingGroup returns the timings of res as a log attribute, with the names
+// of the original response.
+func timingGroup(res api.Response) slog.Attr {
+	t := res.Timing
+	idle, busy := stage(res, workload.StageIdle), stage(res, workload.StageBusy)
+	return slog.Group("timing",
+		"rt0", t.Start, "rts", t.Idle, "rtb", t.Busy, "rit", busy.Iterations,
+		"rcl", t.Call, "rdt", t.Total, "rtf", t.End, "rto", t.IdleOvershoot,
+		"sts", idle.Sampled.Duration, "stb", busy.Sampled.Duration, "sit", busy.Sampled.Iterations,
+		"stc", busy.Sampled.CPU, "rbs", busy.End)
+}
+
+// responseV1 is the original response schema, selected with v=1. Real
+// timings (r*) are Unix ns or durations in ns and sampled targets (s*) are
+// ns or iterations, all as decimal strings, and the host metrics are the
+// raw gopsutil structures.
+type responseV1 struct {
+	RT0      string                  `json:"rt0"`
+	RTS      string                  `json:"rts"`
+	RTB      string                  `json:"rtb"`
+	RIT      string                  `json:"rit"`
+	RCL      string                  `json:"rcl"`
+	RDT      string                  `json:"rdt"`
+	RTF      string                  `json:"rtf"`
+	RTO      string                  `json:"rto"`
+	RTC      string                  `json:"rtc,omitempty"`
+	RBS      string                  `json:"rbs"`
+	STS      string                  `json:"sts"`
+	STB      string                  `json:"stb"`
+	SIT      string                  `json:"sit"`
+	STC      string                  `json:"stc"`
+	IM       string                  `json:"im"`
+	BK       string                  `json:"bk"`
+	Speed    float64                 `json:"speed"`
+	Calls    []workload.CallResult   `json:"calls,omitempty"`
+	Instance string                  `json:"instance"`
+	Exp      string                  `json:"exp,omitempty"`
+	Tail     *workload.TailInjection `json:"tail,omitempty"`
+	Fault    *api.Fault              `json:"fault,omitempty"`
+	TraceID  string                  `json:"trace_id,omitempty"`
+	SpanID   string                  `json:"span_id,omitempty"`
+	Custom   *api.Custom             `json:"custom,omitempty"`
+	hostMetrics
+}
+
+// newResponseV1 renders res in the original schema.
+func newResponseV1(res api.Response, host hostMetrics) responseV1 {
+	t := res.Timing
+	idle, busy := stage(res, workload.StageIdle), stage(res, workload.StageBusy)
+	v1 := responseV1{
+		RBS:         busy.End,
+		IM:          idle.Method,
+		BK:          busy.Kernel,
+		Speed:       busy.Speed,
+		Calls:       stage(res, workload.StageCall).Calls,
+		Instance:    res.Instance.ID,
+		Exp:         res.Experiment,
+		Tail:        res.Tail,
+		Fault:       res.Fault,
+		TraceID:     res.TraceID,
+		SpanID:      res.SpanID,
+		Custom:      res.Custom,
+		hostMetrics: host,
+	}
+	for _, f := range []struct {
+		dst *string
+		v   int64
+	}{
+		{&v1.RT0, t.Start}, {&v1.RTS, t.Idle}, {&v1.RTB, t.Busy}, {&v1.RIT, busy.Iterations},
+		{&v1.RCL, t.Call}, {&v1.RDT, t.Total}, {&v1.RTF, t.End}, {&v1.RTO, t.IdleOvershoot},
+		{&v1.STS, idle.Sampled.Duration}, {&v1.STB, busy.Sampled.Duration},
+		{&v1.SIT, busy.Sampled.Iterations}, {&v1.STC, busy.Sampled.CPU},
+	} {
+		*f.dst = strconv.FormatInt(f.v, 10)
+	}
+	if workload.CPUTimeSupported {
+		v1.RTC = strconv.FormatInt(busy.CPU, 10)
+	}
+	return v1
+}
+
+// handleSchema serves the JSON Schema of the response on /schema and its
+// Protobuf definition on /schema.proto.
+func handleSchema(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		httpError(resp, req, fmt.Sprintf("method %s not allowed", req.Method), 405)
+		return
+	}
+	schema, ct := api.Schema, "application/schema+json"
+	if req.URL.Path == "/schema.proto" {
+		schema, ct = api.Proto, "text/plain; charset=utf-8"
+	}
+	resp.Header().Add("Content-Type", ct)
+	resp.Header().Add("Version", Version)
+	resp.WriteHeader(200)
+	_, _ = resp.Write(schema)
+}
@@ -0,0 +1,78 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"function/api"
+	"function/workload"
+)
+
+// TestHandleResponseV2 ensures that the default response is typed JSON with
+// integer timings, labelled as such.
+func TestHandleResponseV2(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&id=r&ts=1000&it=100", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+	var body api.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Version != api.Version || body.Client != "1" || body.ID != "r" || body.Instance.ID != instanceID {
+		t.Fatalf("unexpected response: %+v", body)
+	}
+	tm := body.Timing
+	if tm.Start <= 0 || tm.End < tm.Start || tm.Total < tm.Idle+tm.Busy || tm.Idle < 1000 {
+		t.Errorf("unexpected timing: %+v", tm)
+	}
+	if len(body.Stages) != 2 || body.Stages[1].Iterations != 100 || body.Stages[0].Target.Duration != 1000 {
+		t.Errorf("unexpected stages: %+v", body.Stages)
+	}
+}
+
+// TestHandleResponseV1 ensures that v=1 keeps the original string-encoded
+// response.
+func TestHandleResponseV1(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&ts=1000&it=100&v=1", nil))
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"rt0", "rts", "rtb", "rdt", "rtf", "sts", "sit"} {
+		if _, ok := body[k].(string); !ok {
+			t.Errorf("%s: not a string: %v", k, body[k])
+		}
+	}
+	if body["rit"] != "100" || body["instance"] != instanceID {
+		t.Errorf("unexpected response: %v", body)
+	}
+}
+
+// TestHandleSchema ensures that the schema endpoint serves a JSON Schema
+// describing every field of the response.
+func TestHandleSchema(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/schema", nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/schema+json" {
+		t.Fatalf("unexpected response: %v %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(api.Response{Tail: new(workload.TailInjection), Fault: new(api.Fault), TraceID: "t", SpanID: "s", ID: "i", T0: "0", Experiment: "e"})
+	var fields map[string]any
+	_ = json.Unmarshal(b, &fields)
+	for k := range fields {
+		if _, ok := schema.Properties[k]; !ok {
+			t.Errorf("%s: missing from the schema", k)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// dripHz is how many chunks per second a rate-limited body is split into.
+const dripHz = 100
+
+// Shaping degrades how a response travels back to the client. Delay holds
+// back the first byte, headers included, and Rate limits the body to that
+// many bytes per second when positive.
+type Shaping struct {
+	Delay time.Duration
+	Rate  int64
+}
+
+// instanceShaping applies to every response of this instance. It is read
+// from SIMTASK_WRITE_DELAY and SIMTASK_WRITE_RATE, and the wd and wr request
+// parameters take precedence over it.
+var instanceShaping = shapingFromEnv()
+
+func shapingFromEnv() Shaping {
+	s, err := parseShaping(envParams(map[string]string{
+		"SIMTASK_WRITE_DELAY": "wd",
+		"SIMTASK_WRITE_RATE":  "wr",
+	}), Shaping{})
+	if err != nil {
+		logger.Warn("ignoring instance shaping", "error", err)
+		return Shaping{}
+	}
+	return s
+}
+
+// parseShaping overrides def with the wd (a duration) and wr (bytes/s)
+// parameters.
+func parseShaping(params url.Values, def Shaping) (Shaping, error) {
+	s := def
+	var errs []error
+	if params.Has("wd") {
+		wd, err := parseDuration(params.Get("wd"))
+		if err != nil {
+			errs = append(errs, badParam("wd", "must be a non-negative duration"))
+		}
+		s.Delay = wd
+	}
+	if params.Has("wr") {
+		wr, err := strconv.ParseInt(params.Get("wr"), 10, 64)
+		if err != nil || wr < 0 {
+			errs = append(errs, badParam("wr", "must be a non-negative integer"))
+		}
+		s.Rate = wr
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Shaping{}, err
+	}
+	return s, nil
+}
+
+// respond writes status and body through the shaping. fault is the
+// transport fault that fired for the request, if any.
+func (s Shaping) respond(ctx context.Context, resp http.ResponseWriter, status int, body []byte, fault string) error {
+	if err := sleepCtx(ctx, s.Delay); err != nil {
+		return err
+	}
+	switch fault {
+	case FaultReset:
+		return reset(resp)
+	case FaultTruncate:
+		body = body[:len(body)/2]
+	case FaultLength:
+		resp.Header().Set("Content-Length", strconv.Itoa(2*len(body)))
+	default:
+		resp.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	resp.WriteHeader(status)
+	if fault == FaultClose {
+		if err := s.drip(ctx, resp, body[:len(body)/2]); err != nil {
+			return err
+		}
+		panic(http.ErrAbortHandler)
+	}
+	return s.drip(ctx, resp, body)
+}
+
+// drip writes body at the shaping rate, flushing every chunk.
+func (s Shaping) drip(ctx context.Context, resp http.ResponseWriter, body []byte) error {
+	if s.Rate <= 0 {
+		_, err := resp.Write(body)
+		return err
+	}
+	chunk := int(s.Rate / dripHz)
+	if chunk < 1 {
+		chunk = 1
+	}
+	interval := time.Duration(int64(chunk) * int64(time.Second) / s.Rate)
+	fl, _ := resp.(http.Flusher)
+	for len(body) > 0 {
+		n := chunk
+		if n > len(body) {
+			n = len(body)
+		}
+		if _, err := resp.Write(body[:n]); err != nil {
+			return err
+		}
+		if fl != nil {
+			fl.Flush()
+		}
+		body = body[n:]
+		if len(body) > 0 {
+			if err := sleepCtx(ctx, interval); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reset drops the connection with a TCP RST. Connections that cannot be
+// hijacked, such as HTTP/2 streams, are aborted instead.
+func reset(resp http.ResponseWriter) error {
+	hj, ok := resp.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	conn, _, err := hj.Hijack()
+	if errors.Is(err, http.ErrNotSupported) {
+		panic(http.ErrAbortHandler)
+	} else if err != nil {
+		return err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetLinger(0)
+	}
+	return conn.Close()
+}
+
+// sleepCtx sleeps for d, returning early with the context error.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
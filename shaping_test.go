@@ -0,0 +1,61 @@
+package function
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestShapingDrip ensures that a rate-limited body takes as long as the
+// rate implies and arrives whole.
+func TestShapingDrip(t *testing.T) {
+	w := httptest.NewRecorder()
+	body := make([]byte, 1000)
+	start := time.Now()
+	err := Shaping{Delay: 10 * time.Millisecond, Rate: 10000}.respond(context.Background(), w, 200, body, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("body sent too fast: %v", d)
+	}
+	if w.Body.Len() != len(body) {
+		t.Fatalf("unexpected body length: %d", w.Body.Len())
+	}
+}
+
+// TestShapingTruncate ensures that a truncated response ends cleanly with
+// half of the body.
+func TestShapingTruncate(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := Shaping{}.respond(context.Background(), w, 200, []byte("0123456789"), FaultTruncate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "01234" {
+		t.Fatalf("unexpected body: %q", w.Body.String())
+	}
+}
+
+// TestHandleTransportFaults ensures that the length and reset faults break
+// the response as seen by a real client.
+func TestHandleTransportFaults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handle(r.Context(), w, r)
+	}))
+	defer srv.Close()
+
+	for _, mode := range []string{FaultLength, FaultReset, FaultClose} {
+		res, err := http.Get(srv.URL + "/?cl=1&ts=0&tb=0&fm=" + mode)
+		if err == nil {
+			_, err = io.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		if err == nil {
+			t.Errorf("%s: expected a transport error", mode)
+		}
+	}
+}
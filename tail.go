@@ -0,0 +1,78 @@
+package function
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+
+	"function/workload"
+)
+
+// instanceTail applies to every request of this instance. It is read from
+// the SIMTASK_TAIL_* variables, and the l* request parameters take
+// precedence over it.
+var instanceTail = tailFromEnv()
+
+func tailFromEnv() workload.Tail {
+	t, err := parseTail(envParams(map[string]string{
+		"SIMTASK_TAIL_P":      "lp",
+		"SIMTASK_TAIL_STAGE":  "ls",
+		"SIMTASK_TAIL_FACTOR": "lx",
+		"SIMTASK_TAIL_EXTRA":  "la",
+		"SIMTASK_TAIL_ALPHA":  "lpa",
+		"SIMTASK_TAIL_SCALE":  "lpm",
+	}), workload.Tail{Stage: workload.StageIdle, Factor: 1})
+	if err != nil {
+		logger.Warn("ignoring instance tail", "error", err)
+		return workload.Tail{Stage: workload.StageIdle, Factor: 1}
+	}
+	return t
+}
+
+// parseTail overrides def with the lp, ls, lx, la, lpa and lpm parameters,
+// la and lpm being durations.
+func parseTail(params url.Values, def workload.Tail) (workload.Tail, error) {
+	t := def
+	var errs []error
+	var err error
+	if params.Has("lp") {
+		t.P, err = strconv.ParseFloat(params.Get("lp"), 64)
+		if err != nil || t.P < 0 || t.P > 1 {
+			errs = append(errs, badParam("lp", "must be a probability in [0, 1]"))
+		}
+	}
+	if params.Has("ls") {
+		t.Stage = params.Get("ls")
+		switch t.Stage {
+		case workload.StageIdle, workload.StageBusy, workload.StageBoth:
+		default:
+			errs = append(errs, badParam("ls", "must be idle, busy or both"))
+		}
+	}
+	if params.Has("lx") {
+		t.Factor, err = strconv.ParseFloat(params.Get("lx"), 64)
+		if err != nil || t.Factor < 0 {
+			errs = append(errs, badParam("lx", "must be a non-negative number"))
+		}
+	}
+	if params.Has("la") {
+		if t.Extra, err = parseDuration(params.Get("la")); err != nil {
+			errs = append(errs, badParam("la", "must be a non-negative duration"))
+		}
+	}
+	if params.Has("lpa") {
+		t.Alpha, err = strconv.ParseFloat(params.Get("lpa"), 64)
+		if err != nil || t.Alpha < 0 {
+			errs = append(errs, badParam("lpa", "must be a non-negative number"))
+		}
+	}
+	if params.Has("lpm") {
+		if t.Scale, err = parseDuration(params.Get("lpm")); err != nil {
+			errs = append(errs, badParam("lpm", "must be a non-negative duration"))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return workload.Tail{}, err
+	}
+	return t, nil
+}
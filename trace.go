@@ -0,0 +1,103 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the request stages. Spans are exported over
+// OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, and appended as JSON lines to
+// SIMTASK_TRACE_FILE when it is set. Without either, trace context is still
+// propagated to downstream calls.
+var tracer = setupTracing()
+
+// tracerProvider is nil when no exporter is configured.
+var tracerProvider *sdktrace.TracerProvider
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+func setupTracing() trace.Tracer {
+	otel.SetTextMapPropagator(propagator)
+	var opts []sdktrace.TracerProviderOption
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		exp, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			logger.Warn("OTLP trace exporter", "error", err)
+		} else {
+			opts = append(opts, sdktrace.WithBatcher(exp))
+		}
+	}
+	if path := os.Getenv("SIMTASK_TRACE_FILE"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err == nil {
+			var exp *stdouttrace.Exporter
+			exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+			// Export synchronously, so that the file is complete even
+			// when the instance crashes.
+			opts = append(opts, sdktrace.WithSyncer(exp))
+		}
+		if err != nil {
+			logger.Warn("file trace exporter", "error", err)
+		}
+	}
+	if len(opts) == 0 {
+		return otel.Tracer("simtask")
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(attribute.String("service.name", "simtask"), attribute.String("service.version", Version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		logger.Warn("trace resource", "error", err)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider.Tracer("simtask")
+}
+
+// flushTracing exports the spans still buffered, before the instance exits.
+func flushTracing() {
+	if tracerProvider != nil {
+		_ = tracerProvider.ForceFlush(context.Background())
+	}
+}
+
+// startRequestSpan starts the server span of req, as a child of the trace
+// context in its traceparent and tracestate headers.
+func startRequestSpan(req *http.Request, name string) (context.Context, trace.Span) {
+	ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	params := req.URL.Query()
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+			attribute.String("simtask.cl", params.Get("cl")),
+			attribute.String("simtask.id", params.Get("id")),
+			attribute.String("simtask.t0", params.Get("t0")),
+		))
+}
+
+// Shutdown waits for the asynchronous tasks, exports the spans still
+// buffered and stops the exporters. Servers embedding Handle call it once
+// they stopped serving requests.
+func Shutdown(ctx context.Context) error {
+	err := tasks.wait(ctx)
+	if tracerProvider == nil {
+		return err
+	}
+	return errors.Join(err, tracerProvider.Shutdown(ctx))
+}
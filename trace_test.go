@@ -0,0 +1,37 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestHandleTraceContext ensures that the incoming trace context is carried
+// by the response and propagated to downstream calls.
+func TestHandleTraceContext(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var downstream string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstream = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://example.com/?cl=1&ts=0&tb=0&call="+srv.URL, nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	Handle(context.Background(), w, req)
+
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["trace_id"] != traceID {
+		t.Errorf("unexpected trace id: %v", body["trace_id"])
+	}
+	if !strings.HasPrefix(downstream, "00-"+traceID+"-") {
+		t.Errorf("unexpected downstream traceparent: %q", downstream)
+	}
+}
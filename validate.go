@@ -0,0 +1,328 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"function/workload"
+)
+
+// paramError is an invalid request parameter. Limit is set when the value
+// is well formed but above the maximum of the instance; Item locates the
+// parameter in the body, as in tasks[2]. for the tasks of a batch.
+type paramError struct {
+	Name   string
+	Reason string
+	Limit  bool
+	Item   string
+}
+
+func (e *paramError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("bad '%s' parameter", e.Name)
+	}
+	return fmt.Sprintf("bad '%s' parameter: %s", e.Name, e.Reason)
+}
+
+func badParam(name, reason string) error {
+	return &paramError{Name: name, Reason: reason}
+}
+
+// parseDuration parses a non-negative duration in ns, or with a unit as in
+// time.ParseDuration.
+func parseDuration(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if ns, ierr := strconv.ParseInt(v, 10, 64); ierr == nil {
+		d, err = time.Duration(ns), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %s", v)
+	}
+	return d, nil
+}
+
+// Limits are the largest values this instance accepts for the request
+// parameters; zero ones are unbounded. Targets drawn from unbounded
+// distributions are capped at their limit rather than rejected. Iterations
+// also caps the iterations converted from tw, and WriteRate is the lowest
+// wr, as slower rates hold responses open longer. Calls bounds the calls of
+// a request and CallDepth how deep they go, both counted over the stages it
+// hands on downstream, and CallFanout how many of a stage run at a time,
+// also capping call stages of unbounded fan-out. Batch bounds the tasks of a batch and BatchFanout
+// how many of them run at a time, also capping batches of unbounded
+// fan-out; DAGNodes bounds the nodes of a DAG.
+type Limits struct {
+	Idle        time.Duration // ts
+	Busy        time.Duration // tb
+	Iterations  int64         // it
+	Work        time.Duration // tw
+	CPU         time.Duration // tc
+	TailFactor  float64       // lx
+	TailExtra   time.Duration // la
+	TailScale   time.Duration // lpm
+	WriteDelay  time.Duration // wd
+	WriteRate   int64         // wr
+	Calls       int
+	CallDepth   int
+	CallFanout  int // cf
+	Batch       int
+	BatchFanout int // bf
+	DAGNodes    int
+}
+
+// limits applies to every request of this instance. It is read from the
+// SIMTASK_MAX_* variables.
+var limits = limitsFromEnv()
+
+func limitsFromEnv() Limits {
+	l, err := parseLimits(envParams(map[string]string{
+		"SIMTASK_MAX_TS":         "ts",
+		"SIMTASK_MAX_TB":         "tb",
+		"SIMTASK_MAX_IT":         "it",
+		"SIMTASK_MAX_TW":         "tw",
+		"SIMTASK_MAX_TC":         "tc",
+		"SIMTASK_MAX_LX":         "lx",
+		"SIMTASK_MAX_LA":         "la",
+		"SIMTASK_MAX_LPM":        "lpm",
+		"SIMTASK_MAX_WD":         "wd",
+		"SIMTASK_MIN_WR":         "wr",
+		"SIMTASK_MAX_CALLS":      "call",
+		"SIMTASK_MAX_CALL_DEPTH": "call_depth",
+		"SIMTASK_MAX_CF":         "cf",
+		"SIMTASK_MAX_BATCH":      "tasks",
+		"SIMTASK_MAX_BF":         "bf",
+		"SIMTASK_MAX_DAG_NODES":  "nodes",
+	}))
+	if err != nil {
+		logger.Warn("ignoring bad limits", "error", err)
+	}
+	return l
+}
+
+// parseLimits reads limits from the parameters they bound, leaving out the
+// malformed ones.
+func parseLimits(params url.Values) (Limits, error) {
+	var l Limits
+	var errs []error
+	for _, d := range []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"ts", &l.Idle}, {"tb", &l.Busy}, {"tw", &l.Work}, {"tc", &l.CPU},
+		{"la", &l.TailExtra}, {"lpm", &l.TailScale}, {"wd", &l.WriteDelay},
+	} {
+		if params.Has(d.name) {
+			v, err := parseDuration(params.Get(d.name))
+			if err != nil {
+				errs = append(errs, badParam(d.name, err.Error()))
+				continue
+			}
+			*d.dst = v
+		}
+	}
+	if params.Has("it") {
+		it, err := strconv.ParseInt(params.Get("it"), 10, 64)
+		if err != nil || it < 0 {
+			errs = append(errs, badParam("it", "must be a non-negative integer"))
+		} else {
+			l.Iterations = it
+		}
+	}
+	if params.Has("lx") {
+		lx, err := strconv.ParseFloat(params.Get("lx"), 64)
+		if err != nil || lx < 0 {
+			errs = append(errs, badParam("lx", "must be a non-negative number"))
+		} else {
+			l.TailFactor = lx
+		}
+	}
+	if params.Has("wr") {
+		wr, err := strconv.ParseInt(params.Get("wr"), 10, 64)
+		if err != nil || wr < 0 {
+			errs = append(errs, badParam("wr", "must be a non-negative integer"))
+		} else {
+			l.WriteRate = wr
+		}
+	}
+	for _, c := range []struct {
+		name string
+		dst  *int
+	}{{"call", &l.Calls}, {"call_depth", &l.CallDepth}, {"cf", &l.CallFanout}, {"tasks", &l.Batch}, {"bf", &l.BatchFanout}, {"nodes", &l.DAGNodes}} {
+		if params.Has(c.name) {
+			n, err := strconv.Atoi(params.Get(c.name))
+			if err != nil || n < 0 {
+				errs = append(errs, badParam(c.name, "must be a non-negative integer"))
+				continue
+			}
+			*c.dst = n
+		}
+	}
+	return l, errors.Join(errs...)
+}
+
+// stageMax returns the caps of the idle and busy stages.
+func (l Limits) stageMax() (idle, busy *workload.Targets) {
+	return &workload.Targets{Duration: l.Idle},
+		&workload.Targets{Duration: l.Busy, Iterations: l.Iterations, CPU: l.CPU}
+}
+
+// checkTargets checks the ts, it, tw, tc and tb distribution specs against
+// the limits. tb is required unless another busy target is given.
+func (l Limits) checkTargets(params url.Values) error {
+	errs := []error{checkDist(params, "ts", true, float64(l.Idle))}
+	if params.Has("it") {
+		errs = append(errs, checkDist(params, "it", false, float64(l.Iterations)))
+	} else if params.Has("tw") {
+		errs = append(errs, checkDist(params, "tw", true, float64(l.Work)))
+	}
+	if params.Has("tc") {
+		if !workload.CPUTimeSupported {
+			errs = append(errs, badParam("tc", "not supported on this platform"))
+		} else {
+			errs = append(errs, checkDist(params, "tc", true, float64(l.CPU)))
+		}
+	}
+	if params.Has("tb") || !(params.Has("it") || params.Has("tw") || params.Has("tc")) {
+		errs = append(errs, checkDist(params, "tb", true, float64(l.Busy)))
+	}
+	return errors.Join(errs...)
+}
+
+// checkDist checks the distribution spec of parameter name, of durations or
+// counts: it must not reach below 0, nor above max when it is bounded.
+func checkDist(params url.Values, name string, duration bool, max float64) error {
+	parse := workload.ParseDist
+	if duration {
+		parse = workload.ParseDurationDist
+	}
+	d, err := parse(params.Get(name))
+	if err != nil {
+		if !params.Has(name) {
+			return badParam(name, "missing")
+		}
+		return badParam(name, err.Error())
+	}
+	lo, hi := workload.Range(d)
+	if lo < 0 {
+		return badParam(name, "must not be negative")
+	}
+	if max > 0 && (lo > max || (!math.IsInf(hi, 1) && hi > max)) {
+		return &paramError{Name: name, Reason: "above the maximum of " + formatLimit(max, duration), Limit: true}
+	}
+	return nil
+}
+
+func formatLimit(v float64, duration bool) string {
+	if duration {
+		return time.Duration(v).String()
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// checkKnobs checks the lx, la, lpm, wd, wr and cf parameters and the
+// number and depth of the calls against the limits. Malformed values are
+// left to their parsers. lpa takes no limit, as the delays it draws are
+// capped with the stage targets.
+func (l Limits) checkKnobs(params url.Values, calls, depth int) error {
+	var errs []error
+	for _, d := range []struct {
+		name string
+		max  time.Duration
+	}{{"la", l.TailExtra}, {"lpm", l.TailScale}, {"wd", l.WriteDelay}} {
+		if v, err := parseDuration(params.Get(d.name)); err == nil && d.max > 0 && v > d.max {
+			errs = append(errs, &paramError{Name: d.name, Reason: "above the maximum of " + d.max.String(), Limit: true})
+		}
+	}
+	if lx, err := strconv.ParseFloat(params.Get("lx"), 64); err == nil && l.TailFactor > 0 && lx > l.TailFactor {
+		errs = append(errs, &paramError{Name: "lx", Reason: "above the maximum of " + formatLimit(l.TailFactor, false), Limit: true})
+	}
+	if wr, err := strconv.ParseInt(params.Get("wr"), 10, 64); err == nil && wr > 0 && wr < l.WriteRate {
+		errs = append(errs, &paramError{Name: "wr", Reason: fmt.Sprintf("below the minimum of %d", l.WriteRate), Limit: true})
+	}
+	if cf, err := strconv.Atoi(params.Get("cf")); err == nil && l.CallFanout > 0 && cf > l.CallFanout {
+		errs = append(errs, &paramError{Name: "cf", Reason: fmt.Sprintf("above the maximum of %d", l.CallFanout), Limit: true})
+	}
+	if l.Calls > 0 && calls > l.Calls {
+		errs = append(errs, &paramError{Name: "call", Reason: fmt.Sprintf("more than %d calls", l.Calls), Limit: true})
+	}
+	if l.CallDepth > 0 && depth > l.CallDepth {
+		errs = append(errs, &paramError{Name: "call", Reason: fmt.Sprintf("calls more than %d levels deep", l.CallDepth), Limit: true})
+	}
+	return errors.Join(errs...)
+}
+
+// problem is an RFC 7807 problem details document. InvalidParams lists the
+// invalid request parameters of a 400.
+type problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	InvalidParams []invalidParam `json:"invalid-params,omitempty"`
+}
+
+type invalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// rejectParams replies with a 400 problem listing the invalid parameters in
+// err, and counts them.
+func rejectParams(resp http.ResponseWriter, req *http.Request, err error) {
+	var invalid []invalidParam
+	for _, e := range flattenErrors(err) {
+		p := &paramError{Reason: e.Error()}
+		errors.As(e, &p)
+		cause := "invalid"
+		if p.Limit {
+			cause = "limit"
+		}
+		rejectedTotal.WithLabelValues(p.Name, cause).Inc()
+		invalid = append(invalid, invalidParam{Name: p.Item + p.Name, Reason: p.Reason})
+	}
+	detail := fmt.Sprintf("%d invalid parameters", len(invalid))
+	if len(invalid) == 1 {
+		detail = "1 invalid parameter"
+	}
+	problemError(resp, req, detail, 400, invalid)
+}
+
+// flattenErrors returns the errors joined in err.
+func flattenErrors(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, e := range j.Unwrap() {
+			errs = append(errs, flattenErrors(e)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
+
+// problemError logs a failed request and replies with an RFC 7807 problem.
+func problemError(resp http.ResponseWriter, req *http.Request, detail string, code int, invalid []invalidParam) {
+	requestLogger(req, req.URL.Query()).Warn("request failed", "status", code, "error", detail, "invalid", invalid)
+	resp.Header().Set("Content-Type", "application/problem+json")
+	resp.Header().Set("Version", Version)
+	resp.WriteHeader(code)
+	enc := json.NewEncoder(resp)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(code),
+		Status:        code,
+		Detail:        detail,
+		Instance:      req.URL.RequestURI(),
+		InvalidParams: invalid,
+	})
+}
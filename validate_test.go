@@ -0,0 +1,114 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"function/api"
+)
+
+// TestHandleInvalid ensures that a rejected request gets a problem listing
+// every invalid parameter, and is counted.
+func TestHandleInvalid(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&ts=-5&tb=abc&bs=x&fp=2&lp=3&la=-1ms", nil))
+	if w.Code != 400 || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("unexpected response: %v %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ip := range p.InvalidParams {
+		names = append(names, ip.Name)
+	}
+	if want := []string{"ts", "tb", "bs", "fp", "lp", "la"}; p.Status != 400 || !slices.Equal(names, want) {
+		t.Fatalf("unexpected problem: %+v", p)
+	}
+
+	w = httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/metrics", nil))
+	if want := `simtask_rejected_requests_total{cause="invalid",param="ts"}`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("metrics lack %s", want)
+	}
+}
+
+// TestHandleUnits ensures that durations take units.
+func TestHandleUnits(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&ts=1.5ms&tb=uniform:10us,20us&wd=1ms", nil))
+	var body api.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if idle := body.Stages[0]; idle.Target.Duration != int64(1500*time.Microsecond) {
+		t.Errorf("unexpected idle stage: %+v", idle)
+	}
+	if busy := body.Stages[1]; busy.Sampled.Duration < 10e3 || busy.Sampled.Duration > 20e3 {
+		t.Errorf("unexpected busy stage: %+v", busy)
+	}
+
+	w = httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&ts=0&it=1ms", nil))
+	if w.Code != 400 {
+		t.Errorf("iterations with a unit: unexpected response code %v", w.Code)
+	}
+}
+
+// TestHandleLimits ensures that bounded targets and knobs beyond the limits
+// are rejected and that unbounded ones are capped.
+func TestHandleLimits(t *testing.T) {
+	defer func(l Limits) { limits = l }(limits)
+	var err error
+	limits, err = parseLimits(url.Values{"ts": {"1ms"}, "it": {"1000"}, "lx": {"10"}, "call": {"1"}, "cf": {"2"}, "wr": {"100"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"ts=2ms&tb=0", "ts=uniform:0,5ms&tb=0", "ts=0&it=1001", "ts=0&tb=0&lx=11",
+		"ts=0&tb=0&call=http://a&call=http://b", "ts=0&tb=0&call=" + url.QueryEscape("http://a/?call=http://b"),
+		"ts=0&tb=0&call=http://a&cf=3", "ts=0&tb=0&wr=10",
+	} {
+		w := httptest.NewRecorder()
+		Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&"+q, nil))
+		var p problem
+		_ = json.Unmarshal(w.Body.Bytes(), &p)
+		if w.Code != 400 || len(p.InvalidParams) != 1 || !strings.Contains(p.InvalidParams[0].Reason, "maximum") && !strings.Contains(p.InvalidParams[0].Reason, "more than") && !strings.Contains(p.InvalidParams[0].Reason, "minimum") {
+			t.Errorf("%s: unexpected response: %v %s", q, w.Code, w.Body.String())
+		}
+	}
+
+	sim, err := parseSimulation(httptest.NewRequest("GET", "http://example.com/", nil), url.Values{"ts": {"0"}, "tb": {"0"}, "call": {"http://a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := sim.task.Stages[2].Calls; calls.Fanout != 2 {
+		t.Errorf("call fan-out not capped: %+v", calls)
+	}
+
+	limits.Calls, limits.CallDepth = 0, 1
+	w := httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("POST", "http://example.com/?cl=1&ts=0&tb=0",
+		strings.NewReader(`{"calls": [{"url": "http://a", "next": {"calls": [{"url": "http://b"}]}}]}`)))
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "levels deep") {
+		t.Errorf("nested calls: unexpected response: %v %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	Handle(context.Background(), w, httptest.NewRequest("GET", "http://example.com/?cl=1&ts=exp:1s&it=10&lp=1&ls=busy&lx=10", nil))
+	var body api.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	idle, busy := body.Stages[0], body.Stages[1]
+	if idle.Target.Duration > int64(time.Millisecond) || busy.Target.Iterations != 100 {
+		t.Errorf("targets not capped: %+v %+v", idle, busy)
+	}
+}
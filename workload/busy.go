@@ -0,0 +1,217 @@
+package workload
+
+import (
+	"crypto/sha256"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Busy kernels. An iteration of each kernel is a fixed amount of CPU work whose cost
+// depends on the node, see Calibration.
+const (
+	KernelLoop  = "loop"  // empty loop iteration
+	KernelHash  = "hash"  // SHA-256 of a 64 byte block
+	KernelFloat = "float" // chain of dependent floating point operations
+)
+
+// Busy stage stop policies.
+const (
+	StopAll = "all" // stop once every target is met, the default
+	StopAny = "any" // stop at the first target met
+	StopCPU = "cpu" // stop once the thread CPU time target is met
+)
+
+// Conditions reported as ending the busy stage.
+const (
+	EndNone       = "none"
+	EndIterations = "iterations"
+	EndWall       = "wall"
+	EndCPU        = "cpu"
+)
+
+// checkInterval is about how long the iterations handed out between two
+// checks of the clock take, whatever the cost of the kernel.
+const checkInterval = 4 * time.Microsecond
+
+// cpuCheckEvery is how many checks apart the thread CPU time is read, as
+// reading it costs a system call.
+const cpuCheckEvery = 4
+
+// Busy is the target of the busy stage: a number of iterations, a wall time
+// and a thread CPU time, combined by Policy. Zero targets are unset.
+type Busy struct {
+	Policy string
+	It     int64
+	Wall   time.Duration
+	CPU    time.Duration
+}
+
+// BusyResult is what a busy stage did and which condition ended it.
+type BusyResult struct {
+	It  int64
+	CPU time.Duration
+	End string
+}
+
+// kernels run iterations of their work for as long as the stopper allows,
+// and return the number of iterations run.
+var kernels = map[string]func(s *stopper) int64{
+	KernelLoop:  loopKernel,
+	KernelHash:  hashKernel,
+	KernelFloat: floatKernel,
+}
+
+// Kernels returns the names of the busy kernels, sorted.
+func Kernels() []string {
+	names := make([]string, 0, len(kernels))
+	for name := range kernels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sink keeps the compiler from eliding the kernels' work, which may run
+// concurrently.
+var sink uint64
+
+func loopKernel(s *stopper) int64 {
+	rit := int64(0)
+	for n := s.until(rit); n >= 0; n = s.until(rit) {
+		for ; rit < n; rit++ {
+		}
+	}
+	return rit
+}
+
+func hashKernel(s *stopper) int64 {
+	var block [64]byte
+	rit := int64(0)
+	for n := s.until(rit); n >= 0; n = s.until(rit) {
+		for ; rit < n; rit++ {
+			sum := sha256.Sum256(block[:])
+			copy(block[:], sum[:])
+		}
+	}
+	atomic.AddUint64(&sink, uint64(block[0]))
+	return rit
+}
+
+func floatKernel(s *stopper) int64 {
+	x := 1.0
+	rit := int64(0)
+	for n := s.until(rit); n >= 0; n = s.until(rit) {
+		for ; rit < n; rit++ {
+			x = x*1.000000001 + 1e-9
+			x = x / 1.000000001
+		}
+	}
+	atomic.AddUint64(&sink, uint64(x))
+	return rit
+}
+
+// stopper decides when a busy stage ends.
+type stopper struct {
+	Busy
+	start  time.Time
+	last   time.Time
+	chunk  int64
+	checks int64
+	cpu0   time.Duration
+	cpuMet bool
+	end    string
+}
+
+// until returns how many iterations the stage may reach after rit before
+// asking again, or -1 once it is over. The iteration target of the default
+// policy is handed out whole, so those iterations cost no more than a bare
+// loop. Otherwise iterations are handed out in chunks, never past the
+// iteration target of the any policy, sized so that a chunk takes about
+// checkInterval.
+func (s *stopper) until(rit int64) int64 {
+	if rit < s.It && s.Policy == StopAll {
+		return s.It
+	}
+	now := time.Now()
+	if s.check(rit, now) {
+		return -1
+	}
+	switch elapsed := now.Sub(s.last); {
+	case s.chunk == 0:
+		s.chunk = 1
+	case elapsed < checkInterval/2:
+		s.chunk *= 2
+	case elapsed > checkInterval*2 && s.chunk > 1:
+		s.chunk /= 2
+	}
+	s.last = now
+	n := rit + s.chunk
+	if s.Policy == StopAny && rit < s.It && n > s.It {
+		n = s.It
+	}
+	return n
+}
+
+func (s *stopper) check(rit int64, now time.Time) bool {
+	itMet := rit >= s.It
+	wallMet := now.Sub(s.start) >= s.Wall
+	if s.CPU > 0 && !s.cpuMet && s.checks%cpuCheckEvery == 0 {
+		cpu, _ := threadCPUTime()
+		s.cpuMet = cpu-s.cpu0 >= s.CPU
+	}
+	s.checks++
+	cpuMet := s.CPU <= 0 || s.cpuMet
+	switch s.Policy {
+	case StopAny:
+		switch {
+		case s.It > 0 && itMet:
+			s.end = EndIterations
+		case s.Wall > 0 && wallMet:
+			s.end = EndWall
+		case s.CPU > 0 && cpuMet:
+			s.end = EndCPU
+		case s.It <= 0 && s.Wall <= 0 && s.CPU <= 0:
+			s.end = EndNone
+		default:
+			return false
+		}
+		return true
+	case StopCPU:
+		s.end = EndCPU
+		return cpuMet
+	default:
+		// The condition that held the stage last is the one that ended it.
+		switch {
+		case !itMet:
+			s.end = EndIterations
+		case !wallMet:
+			s.end = EndWall
+		case !cpuMet:
+			s.end = EndCPU
+		default:
+			if s.end == "" && s.It > 0 {
+				// Only the iterations handed out by until held the stage.
+				s.end = EndIterations
+			} else if s.end == "" {
+				s.end = EndNone
+			}
+			return true
+		}
+		return false
+	}
+}
+
+// Run executes the busy stage with kernel on the calling goroutine, locked
+// to its thread so that the thread CPU time is that of the stage. The kernel
+// must be one of Kernels.
+func (b Busy) Run(kernel string) BusyResult {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	s := &stopper{Busy: b, start: time.Now()}
+	s.cpu0, _ = threadCPUTime()
+	rit := kernels[kernel](s)
+	cpu, _ := threadCPUTime()
+	return BusyResult{It: rit, CPU: cpu - s.cpu0, End: s.end}
+}
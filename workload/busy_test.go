@@ -0,0 +1,56 @@
+package workload
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBusyPolicies ensures that each stop policy ends the busy stage on the
+// expected condition.
+func TestBusyPolicies(t *testing.T) {
+	type busyCase struct {
+		busy Busy
+		end  string
+	}
+	cases := []busyCase{
+		{Busy{Policy: StopAll, It: 1000}, EndIterations},
+		{Busy{Policy: StopAll, It: 10, Wall: time.Millisecond}, EndWall},
+		{Busy{Policy: StopAll}, EndNone},
+		{Busy{Policy: StopAny, It: 1 << 50, Wall: time.Millisecond}, EndWall},
+		{Busy{Policy: StopAny, It: 1000, Wall: time.Hour}, EndIterations},
+	}
+	if CPUTimeSupported {
+		cases = append(cases,
+			busyCase{Busy{Policy: StopCPU, It: 1 << 50, CPU: time.Millisecond}, EndCPU},
+			busyCase{Busy{Policy: StopAll, Wall: time.Millisecond, CPU: 2 * time.Millisecond}, EndCPU})
+	}
+	for _, c := range cases {
+		res := c.busy.Run(KernelLoop)
+		if res.End != c.end {
+			t.Errorf("%+v: ended on %q, want %q", c.busy, res.End, c.end)
+		}
+		if c.busy.It > 0 && c.end == EndIterations && res.It != c.busy.It {
+			t.Errorf("%+v: ran %d iterations", c.busy, res.It)
+		}
+		if c.end == EndCPU && res.CPU < c.busy.CPU {
+			t.Errorf("%+v: used %v of CPU time", c.busy, res.CPU)
+		}
+	}
+}
+
+// TestBusyIterationRate ensures that iterations cost about the same under
+// every stop policy, the clock being read between chunks of them.
+func TestBusyIterationRate(t *testing.T) {
+	const it = 20000000
+	elapsed := func(b Busy) time.Duration {
+		start := time.Now()
+		if res := b.Run(KernelLoop); res.It != it {
+			t.Errorf("%+v: ran %d iterations", b, res.It)
+		}
+		return time.Since(start)
+	}
+	all := elapsed(Busy{Policy: StopAll, It: it})
+	if first := elapsed(Busy{Policy: StopAny, It: it, Wall: time.Hour}); first > 5*all+10*time.Millisecond {
+		t.Errorf("%d iterations took %v under the any policy, %v under the default one", it, first, all)
+	}
+}
@@ -0,0 +1,105 @@
+package workload
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// calibrationWindow is the minimum duration of a kernel measurement.
+const calibrationWindow = 20 * time.Millisecond
+
+// Calibration is the speed of the busy kernels on this node, in iterations
+// per nanosecond, next to their speed on the reference hardware. Speed is
+// the ratio of the two, above 1 on nodes faster than the reference.
+type Calibration struct {
+	At        time.Time          `json:"at"`
+	Rates     map[string]float64 `json:"rates"`
+	Reference map[string]float64 `json:"reference"`
+	Speed     map[string]float64 `json:"speed"`
+}
+
+// reference holds the kernel rates of the reference hardware, in iterations
+// per nanosecond. The defaults are rounded measurements of an Intel Xeon
+// node.
+var reference = struct {
+	sync.RWMutex
+	rates map[string]float64
+}{rates: map[string]float64{KernelLoop: 1.5, KernelHash: 0.005, KernelFloat: 0.1}}
+
+// SetReferenceRate sets the rate of kernel on the reference hardware. It
+// applies to the reference work of later tasks and to the next calibration.
+func SetReferenceRate(kernel string, rate float64) error {
+	if _, ok := kernels[kernel]; !ok {
+		return fmt.Errorf("unknown kernel %q", kernel)
+	}
+	if rate <= 0 {
+		return fmt.Errorf("bad %s reference rate %v", kernel, rate)
+	}
+	reference.Lock()
+	defer reference.Unlock()
+	reference.rates[kernel] = rate
+	return nil
+}
+
+// ReferenceIterations converts a duration of work on the reference hardware
+// into iterations of kernel, saturating at math.MaxInt64.
+func ReferenceIterations(kernel string, work int64) int64 {
+	reference.RLock()
+	defer reference.RUnlock()
+	return saturate(float64(work) * reference.rates[kernel])
+}
+
+var calibration struct {
+	sync.Mutex
+	table *Calibration
+}
+
+// CurrentCalibration returns the calibration table, measuring it first if
+// the process has not done so yet.
+func CurrentCalibration() Calibration {
+	calibration.Lock()
+	defer calibration.Unlock()
+	if calibration.table == nil {
+		c := calibrate()
+		calibration.table = &c
+	}
+	return *calibration.table
+}
+
+// Recalibrate measures the kernels again and replaces the table.
+func Recalibrate() Calibration {
+	calibration.Lock()
+	defer calibration.Unlock()
+	c := calibrate()
+	calibration.table = &c
+	return c
+}
+
+func calibrate() Calibration {
+	c := Calibration{
+		At:        time.Now(),
+		Rates:     map[string]float64{},
+		Reference: map[string]float64{},
+		Speed:     map[string]float64{},
+	}
+	reference.RLock()
+	for name, rate := range reference.rates {
+		c.Reference[name] = rate
+	}
+	reference.RUnlock()
+	for name := range kernels {
+		// Double the iterations until a run spans the window, as iteration
+		// targets are met without checking the clock.
+		for n := int64(1024); ; n *= 2 {
+			start := time.Now()
+			Busy{Policy: StopAll, It: n}.Run(name)
+			if d := time.Since(start); d >= calibrationWindow {
+				c.Rates[name] = float64(n) / float64(d.Nanoseconds())
+				break
+			}
+		}
+		c.Speed[name] = c.Rates[name] / c.Reference[name]
+	}
+	return c
+}
@@ -0,0 +1,230 @@
+package workload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Call stage modes.
+const (
+	CallSequential = "seq"
+	CallParallel   = "par"
+)
+
+// CallStage is the downstream functions a call stage calls. It is also the
+// JSON body of the POST requests that hand a stage on to a downstream
+// function.
+type CallStage struct {
+	Mode   string `json:"mode,omitempty"`
+	Fanout int    `json:"fanout,omitempty"`
+	Calls  []Call `json:"calls"`
+}
+
+// Call is a downstream function and the task it is asked to simulate. Host
+// overrides the Host header, for Knative routing; Params are the task
+// parameters; Next is the call stage of the downstream function in turn.
+type Call struct {
+	URL    string            `json:"url"`
+	Host   string            `json:"host,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+	Next   *CallStage        `json:"next,omitempty"`
+}
+
+// Origin identifies the task a downstream call is made for: its client,
+// request id, client send time and experiment tag, which downstream tasks
+// inherit so that the logs of a chain can be joined.
+type Origin struct {
+	Client     string `json:"cl,omitempty"`
+	ID         string `json:"id,omitempty"`
+	T0         string `json:"t0,omitempty"`
+	Experiment string `json:"exp,omitempty"`
+}
+
+// Client sends the downstream calls.
+var Client = &http.Client{}
+
+// MaxCallBody is how much of a downstream response is recorded; the rest is
+// cut off and reported in the call error.
+const MaxCallBody = 1 << 20
+
+// CallResult is the outcome of a Call as seen by the caller. Start is in
+// Unix ns and Latency in ns; Body embeds the downstream response.
+type CallResult struct {
+	URL     string          `json:"url"`
+	Host    string          `json:"host,omitempty"`
+	Status  int             `json:"status"`
+	Start   int64           `json:"start"`
+	Latency int64           `json:"latency"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Validate checks the mode and the URLs of the stage and of the stages
+// handed on to downstream functions.
+func (s *CallStage) Validate() error {
+	switch s.Mode {
+	case "", CallSequential, CallParallel:
+	default:
+		return fmt.Errorf("bad call stage mode %q", s.Mode)
+	}
+	for _, c := range s.Calls {
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("bad call url %q", c.URL)
+		}
+		if c.Next != nil {
+			if err := c.Next.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Tree returns how many calls the stage makes, counting the ones of the
+// stages handed on downstream, and how many levels deep they go. The calls
+// that call parameters ask of a downstream function, in the query of its URL
+// or in Params, make up its stage along with Next.
+func (s *CallStage) Tree() (calls, depth int) {
+	for _, c := range s.Calls {
+		n, d := c.tree()
+		calls += n
+		depth = max(depth, d)
+	}
+	return calls, depth
+}
+
+func (c Call) tree() (calls, depth int) {
+	var next CallStage
+	if c.Next != nil {
+		next.Calls = append(next.Calls, c.Next.Calls...)
+	}
+	urls := []string{c.Params["call"]}
+	if _, ok := c.Params["call"]; !ok {
+		if u, err := url.Parse(c.URL); err == nil {
+			urls = u.Query()["call"]
+		}
+	}
+	for _, u := range urls {
+		next.Calls = append(next.Calls, Call{URL: u})
+	}
+	calls, depth = next.Tree()
+	return calls + 1, depth + 1
+}
+
+// run makes the calls of the stage. Downstream tasks inherit the origin and
+// get its id suffixed with their index.
+func (s CallStage) run(ctx context.Context, origin Origin) []CallResult {
+	res := make([]CallResult, len(s.Calls))
+	if s.Mode != CallParallel {
+		for i, c := range s.Calls {
+			res[i] = c.Do(ctx, origin, strconv.Itoa(i))
+		}
+		return res
+	}
+	fanout := s.Fanout
+	if fanout <= 0 {
+		fanout = len(s.Calls)
+	}
+	sem := make(chan struct{}, fanout)
+	var wg sync.WaitGroup
+	for i, c := range s.Calls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, c Call) {
+			defer wg.Done()
+			res[i] = c.Do(ctx, origin, strconv.Itoa(i))
+			<-sem
+		}(i, c)
+	}
+	wg.Wait()
+	return res
+}
+
+// Do makes the call on behalf of origin, with the downstream id being the
+// origin one followed by suffix. Query parameters of the URL and Params take
+// precedence over the inherited ones.
+func (c Call) Do(ctx context.Context, origin Origin, suffix string) CallResult {
+	res := CallResult{URL: c.URL, Host: c.Host}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	q := u.Query()
+	for k, v := range map[string]string{"cl": origin.Client, "t0": origin.T0, "exp": origin.Experiment} {
+		if !q.Has(k) && v != "" {
+			q.Set(k, v)
+		}
+	}
+	if !q.Has("id") {
+		q.Set("id", origin.ID+"."+suffix)
+	}
+	for k, v := range c.Params {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+
+	method, body := http.MethodGet, []byte(nil)
+	if c.Next != nil {
+		method = http.MethodPost
+		if body, err = json.Marshal(c.Next); err != nil {
+			res.Error = err.Error()
+			return res
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if c.Host != "" {
+		req.Host = c.Host
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	ctx, span := tracer.Start(ctx, "call "+req.Host, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("url.full", u.String()), attribute.String("simtask.id", q.Get("id"))))
+	defer span.End()
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	res.Start = start.UnixNano()
+	resp, err := Client.Do(req)
+	if err != nil {
+		res.Latency = time.Since(start).Nanoseconds()
+		res.Error = err.Error()
+		return res
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, MaxCallBody+1))
+	res.Latency = time.Since(start).Nanoseconds()
+	res.Status = resp.StatusCode
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if err != nil {
+		res.Error = err.Error()
+	} else if len(b) > MaxCallBody {
+		b = b[:MaxCallBody]
+		res.Error = fmt.Sprintf("response body cut off at %d bytes", MaxCallBody)
+	}
+	if json.Valid(b) {
+		res.Body = b
+	} else if len(b) > 0 {
+		res.Body, _ = json.Marshal(string(b))
+	}
+	return res
+}
@@ -0,0 +1,20 @@
+package workload
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// CPUTimeSupported reports whether busy stages can measure and target
+// thread CPU time on this platform.
+const CPUTimeSupported = true
+
+// threadCPUTime returns the CPU time consumed by the calling thread.
+func threadCPUTime() (time.Duration, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_THREAD_CPUTIME_ID, &ts); err != nil {
+		return 0, err
+	}
+	return time.Duration(ts.Nano()), nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package workload
+
+import (
+	"errors"
+	"time"
+)
+
+// CPUTimeSupported reports whether busy stages can measure and target
+// thread CPU time on this platform.
+const CPUTimeSupported = false
+
+func threadCPUTime() (time.Duration, error) {
+	return 0, errors.New("thread CPU time not supported on this platform")
+}
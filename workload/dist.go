@@ -0,0 +1,272 @@
+package workload
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dist is a distribution that task durations and iteration counts are
+// sampled from.
+type Dist interface {
+	Sample(rng *rand.Rand) float64
+}
+
+// Spec is a distribution spec in the syntax of ParseDist. Its JSON form is a
+// string, or a number for a constant. The empty spec is unset.
+type Spec string
+
+// UnmarshalJSON accepts a string or a number.
+func (s *Spec) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		*s = Spec(v)
+	case float64:
+		*s = Spec(string(b))
+	default:
+		return fmt.Errorf("bad distribution spec %s", b)
+	}
+	return nil
+}
+
+// sample draws a non-negative integer from the spec, or 0 when it is unset.
+// The values of duration specs may carry units.
+func (s Spec) sample(rng *rand.Rand, duration bool) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := s.parse(duration)
+	if err != nil {
+		return 0, err
+	}
+	return sampleInt(d, rng), nil
+}
+
+func (s Spec) parse(duration bool) (Dist, error) {
+	if duration {
+		return ParseDurationDist(string(s))
+	}
+	return ParseDist(string(s))
+}
+
+// ParseDist parses a distribution spec of the form name:arg,arg,... A plain
+// number is a constant. The supported forms are
+//
+//	const:v
+//	uniform:min,max
+//	exp:mean
+//	lognormal:mu,sigma
+//	weibull:shape,scale
+//	pareto:alpha,scale
+//	ecdf:v1@p1,v2@p2,...
+//
+// where the empirical CDF lists values with their increasing cumulative
+// probabilities, the last of which must be 1.
+func ParseDist(spec string) (Dist, error) {
+	return parseDist(spec, parseNumber)
+}
+
+// ParseDurationDist parses a distribution spec of durations in ns, whose
+// values may also carry a unit as in time.ParseDuration: 150ms, exp:2.5us
+// or ecdf:1ms@0.5,1s@1. Shape parameters and probabilities take no unit.
+func ParseDurationDist(spec string) (Dist, error) {
+	return parseDist(spec, parseNanoseconds)
+}
+
+func parseNumber(v string) (float64, error) {
+	return strconv.ParseFloat(v, 64)
+}
+
+// parseNanoseconds parses a number of ns or a duration with a unit.
+func parseNanoseconds(v string) (float64, error) {
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f, nil
+	}
+	d, err := time.ParseDuration(v)
+	return float64(d), err
+}
+
+// parseDist parses spec with value parsing the values of the distribution.
+func parseDist(spec string, value func(string) (float64, error)) (Dist, error) {
+	if v, err := value(spec); err == nil {
+		return ConstDist(v), nil
+	}
+	name, args, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("%q is neither a number nor a distribution", spec)
+	}
+	if name == "ecdf" {
+		return parseEmpirical(args, value)
+	}
+	// Shape parameters of lognormal (both), weibull and pareto take no unit.
+	unitless := map[string][]bool{"lognormal": {true, true}, "weibull": {true, false}, "pareto": {true, false}}[name]
+	var p []float64
+	for i, a := range strings.Split(args, ",") {
+		parse := value
+		if i < len(unitless) && unitless[i] {
+			parse = parseNumber
+		}
+		v, err := parse(a)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s argument %q", name, a)
+		}
+		p = append(p, v)
+	}
+	arity := map[string]int{"const": 1, "uniform": 2, "exp": 1, "lognormal": 2, "weibull": 2, "pareto": 2}
+	n, ok := arity[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown distribution %q", name)
+	}
+	if len(p) != n {
+		return nil, fmt.Errorf("%s takes %d arguments", name, n)
+	}
+	switch name {
+	case "const":
+		return ConstDist(p[0]), nil
+	case "uniform":
+		if p[1] < p[0] {
+			return nil, fmt.Errorf("uniform max below min")
+		}
+		return UniformDist{Min: p[0], Max: p[1]}, nil
+	case "exp":
+		if p[0] <= 0 {
+			return nil, fmt.Errorf("exp mean must be positive")
+		}
+		return ExpDist{Mean: p[0]}, nil
+	case "lognormal":
+		if p[1] < 0 {
+			return nil, fmt.Errorf("lognormal sigma must not be negative")
+		}
+		return LognormalDist{Mu: p[0], Sigma: p[1]}, nil
+	case "weibull":
+		if p[0] <= 0 || p[1] <= 0 {
+			return nil, fmt.Errorf("weibull shape and scale must be positive")
+		}
+		return WeibullDist{Shape: p[0], Scale: p[1]}, nil
+	default:
+		if p[0] <= 0 || p[1] <= 0 {
+			return nil, fmt.Errorf("pareto alpha and scale must be positive")
+		}
+		return ParetoDist{Alpha: p[0], Scale: p[1]}, nil
+	}
+}
+
+func parseEmpirical(args string, value func(string) (float64, error)) (Dist, error) {
+	var d EmpiricalDist
+	for _, a := range strings.Split(args, ",") {
+		vs, ps, ok := strings.Cut(a, "@")
+		if !ok {
+			return nil, fmt.Errorf("bad ecdf point %q", a)
+		}
+		v, err := value(vs)
+		if err != nil {
+			return nil, fmt.Errorf("bad ecdf point %q", a)
+		}
+		p, err := strconv.ParseFloat(ps, 64)
+		if err != nil || p <= 0 || p > 1 || (len(d.P) > 0 && p <= d.P[len(d.P)-1]) {
+			return nil, fmt.Errorf("bad ecdf probability %q", a)
+		}
+		d.V, d.P = append(d.V, v), append(d.P, p)
+	}
+	if d.P[len(d.P)-1] != 1 {
+		return nil, fmt.Errorf("ecdf must end at probability 1")
+	}
+	return d, nil
+}
+
+// ConstDist always samples its own value.
+type ConstDist float64
+
+func (d ConstDist) Sample(*rand.Rand) float64 { return float64(d) }
+
+// UniformDist samples uniformly in [Min, Max).
+type UniformDist struct{ Min, Max float64 }
+
+func (d UniformDist) Sample(rng *rand.Rand) float64 {
+	return d.Min + rng.Float64()*(d.Max-d.Min)
+}
+
+// ExpDist is the exponential distribution with the given mean.
+type ExpDist struct{ Mean float64 }
+
+func (d ExpDist) Sample(rng *rand.Rand) float64 { return rng.ExpFloat64() * d.Mean }
+
+// LognormalDist samples exp(N(Mu, Sigma²)).
+type LognormalDist struct{ Mu, Sigma float64 }
+
+func (d LognormalDist) Sample(rng *rand.Rand) float64 {
+	return math.Exp(d.Mu + d.Sigma*rng.NormFloat64())
+}
+
+// WeibullDist is the Weibull distribution with the given shape and scale.
+type WeibullDist struct{ Shape, Scale float64 }
+
+func (d WeibullDist) Sample(rng *rand.Rand) float64 {
+	return d.Scale * math.Pow(-math.Log(1-rng.Float64()), 1/d.Shape)
+}
+
+// ParetoDist is the Pareto distribution with shape Alpha, taking values
+// from Scale upwards.
+type ParetoDist struct{ Alpha, Scale float64 }
+
+func (d ParetoDist) Sample(rng *rand.Rand) float64 {
+	// Inverse transform of the CDF 1 - (scale/x)^alpha.
+	return d.Scale / math.Pow(1-rng.Float64(), 1/d.Alpha)
+}
+
+// EmpiricalDist samples value V[i] with cumulative probability P[i].
+type EmpiricalDist struct{ V, P []float64 }
+
+func (d EmpiricalDist) Sample(rng *rand.Rand) float64 {
+	u := rng.Float64()
+	return d.V[sort.SearchFloat64s(d.P, u)]
+}
+
+// Range returns the smallest and largest values d samples, the largest being
+// +Inf for unbounded distributions. Values are before rounding, so the
+// samples of a distribution reaching below 0 are clamped to 0.
+func Range(d Dist) (lo, hi float64) {
+	switch d := d.(type) {
+	case ConstDist:
+		return float64(d), float64(d)
+	case UniformDist:
+		return d.Min, d.Max
+	case ParetoDist:
+		return d.Scale, math.Inf(1)
+	case EmpiricalDist:
+		lo, hi = math.Inf(1), math.Inf(-1)
+		for _, v := range d.V {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		return lo, hi
+	case ExpDist, LognormalDist, WeibullDist:
+		return 0, math.Inf(1)
+	}
+	return math.Inf(-1), math.Inf(1)
+}
+
+// sampleInt draws a non-negative integer from d.
+func sampleInt(d Dist, rng *rand.Rand) int64 {
+	return saturate(math.Round(d.Sample(rng)))
+}
+
+// saturate converts v to an integer, clamped to 0 and math.MaxInt64 rather
+// than wrapping around, as heavy-tailed samples may overflow.
+func saturate(v float64) int64 {
+	switch {
+	case !(v > 0):
+		return 0
+	case v >= math.MaxInt64:
+		return math.MaxInt64
+	}
+	return int64(v)
+}
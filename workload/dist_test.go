@@ -0,0 +1,71 @@
+package workload
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestParseDist ensures that valid specs parse and invalid ones are
+// rejected.
+func TestParseDist(t *testing.T) {
+	for _, spec := range []string{"1000", "const:5", "uniform:1,2", "exp:10", "lognormal:1,0.5",
+		"weibull:1.5,100", "pareto:2,100", "ecdf:10@0.5,20@0.9,30@1"} {
+		if _, err := ParseDist(spec); err != nil {
+			t.Errorf("%s: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "foo:1", "exp:-1", "uniform:2,1", "uniform:1",
+		"ecdf:10@0.5", "ecdf:10@0.5,20@0.4,30@1"} {
+		if _, err := ParseDist(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+// TestParseDurationDist ensures that duration specs take units on their
+// values but not on their shapes, and that Range bounds them.
+func TestParseDurationDist(t *testing.T) {
+	for spec, want := range map[string][2]float64{
+		"150ms":             {150e6, 150e6},
+		"2500":              {2500, 2500},
+		"uniform:1us,2ms":   {1e3, 2e6},
+		"pareto:1.5,10ms":   {10e6, math.Inf(1)},
+		"ecdf:1ms@0.5,1s@1": {1e6, 1e9},
+		"exp:1ms":           {0, math.Inf(1)},
+	} {
+		d, err := ParseDurationDist(spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if lo, hi := Range(d); lo != want[0] || hi != want[1] {
+			t.Errorf("%s: unexpected range [%v, %v]", spec, lo, hi)
+		}
+	}
+	for _, spec := range []string{"150ms", "lognormal:1ms,0.5", "weibull:1.5s,1s"} {
+		if _, err := ParseDist(spec); err == nil {
+			t.Errorf("%s: expected an error without units", spec)
+		}
+	}
+	if _, err := ParseDurationDist("lognormal:1ms,0.5"); err == nil {
+		t.Errorf("expected an error for a unit on a shape")
+	}
+}
+
+// TestEmpiricalDist ensures that an empirical CDF only yields its values,
+// with roughly the configured frequencies.
+func TestEmpiricalDist(t *testing.T) {
+	d, err := ParseDist("ecdf:10@0.25,20@1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	n := map[float64]int{}
+	for i := 0; i < 10000; i++ {
+		n[d.Sample(rng)]++
+	}
+	if len(n) != 2 || n[10] < 2200 || n[10] > 2800 {
+		t.Fatalf("unexpected sample counts: %v", n)
+	}
+}
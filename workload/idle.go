@@ -0,0 +1,73 @@
+package workload
+
+import (
+	"context"
+	"time"
+)
+
+// Idle methods of the idle stage. They trade CPU use for how closely the
+// stage meets its target.
+const (
+	IdleSleep     = "sleep"      // time.Sleep
+	IdleTimerSpin = "timer-spin" // time.Sleep, then spin through the last spinMargin
+	IdleNanosleep = "nanosleep"  // clock_nanosleep on a CLOCK_MONOTONIC absolute deadline
+	IdleTimerfd   = "timerfd"    // blocking read of a CLOCK_MONOTONIC timerfd
+	IdleSpin      = "spin"       // spin on the clock for the whole stage
+)
+
+// spinMargin is how long before the deadline IdleTimerSpin stops sleeping,
+// covering the usual timer overshoot.
+const spinMargin = 200 * time.Microsecond
+
+// Idle waits for d with the given method, or until ctx is done, returning
+// the error of ctx then. The nanosleep and timerfd methods block in a system
+// call, so ctx is only checked before they start.
+func Idle(ctx context.Context, method string, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(d)
+	switch method {
+	case IdleTimerSpin:
+		if d > spinMargin {
+			if err := sleep(ctx, d-spinMargin); err != nil {
+				return err
+			}
+		}
+		return spinUntil(ctx, deadline)
+	case IdleSpin:
+		return spinUntil(ctx, deadline)
+	case IdleNanosleep:
+		return nanosleep(d)
+	case IdleTimerfd:
+		return timerfdSleep(d)
+	default:
+		return sleep(ctx, d)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func spinUntil(ctx context.Context, deadline time.Time) error {
+	done := ctx.Done()
+	for time.Now().Before(deadline) {
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package workload
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// IdleSyscalls reports whether IdleNanosleep and IdleTimerfd are available
+// on this platform.
+const IdleSyscalls = true
+
+// monotonicDeadline returns the CLOCK_MONOTONIC time d from now.
+func monotonicDeadline(d time.Duration) (unix.Timespec, error) {
+	var now unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+		return now, err
+	}
+	return unix.NsecToTimespec(now.Nano() + d.Nanoseconds()), nil
+}
+
+func nanosleep(d time.Duration) error {
+	deadline, err := monotonicDeadline(d)
+	if err != nil {
+		return err
+	}
+	for {
+		err = unix.ClockNanosleep(unix.CLOCK_MONOTONIC, unix.TIMER_ABSTIME, &deadline, nil)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func timerfdSleep(d time.Duration) error {
+	fd, err := unix.TimerfdCreate(unix.CLOCK_MONOTONIC, unix.TFD_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	deadline, err := monotonicDeadline(d)
+	if err != nil {
+		return err
+	}
+	err = unix.TimerfdSettime(fd, unix.TFD_TIMER_ABSTIME, &unix.ItimerSpec{Value: deadline}, nil)
+	if err != nil {
+		return err
+	}
+	// The read blocks until the timer expires and yields the expiry count.
+	var buf [8]byte
+	for {
+		_, err = unix.Read(fd, buf[:])
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
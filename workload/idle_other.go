@@ -0,0 +1,18 @@
+//go:build !linux
+
+package workload
+
+import (
+	"errors"
+	"time"
+)
+
+// IdleSyscalls reports whether IdleNanosleep and IdleTimerfd are available
+// on this platform.
+const IdleSyscalls = false
+
+var errIdleUnsupported = errors.New("idle method not supported on this platform")
+
+func nanosleep(time.Duration) error { return errIdleUnsupported }
+
+func timerfdSleep(time.Duration) error { return errIdleUnsupported }
@@ -0,0 +1,39 @@
+package workload
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestIdleMethods ensures that every idle method waits at least its target.
+func TestIdleMethods(t *testing.T) {
+	methods := []string{IdleSleep, IdleTimerSpin, IdleSpin}
+	if IdleSyscalls {
+		methods = append(methods, IdleNanosleep, IdleTimerfd)
+	}
+	for _, m := range methods {
+		d := 2 * time.Millisecond
+		start := time.Now()
+		if err := Idle(context.Background(), m, d); err != nil {
+			t.Fatalf("%s: %v", m, err)
+		}
+		if got := time.Since(start); got < d {
+			t.Errorf("%s: returned after %v, before its %v target", m, got, d)
+		}
+	}
+}
+
+// TestIdleCanceled ensures that the interruptible idle methods return once
+// their context is done.
+func TestIdleCanceled(t *testing.T) {
+	for _, m := range []string{IdleSleep, IdleTimerSpin, IdleSpin} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		start := time.Now()
+		err := Idle(ctx, m, time.Minute)
+		cancel()
+		if err != context.DeadlineExceeded || time.Since(start) > time.Second {
+			t.Errorf("%s: returned %v after %v", m, err, time.Since(start))
+		}
+	}
+}
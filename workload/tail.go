@@ -0,0 +1,69 @@
+package workload
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Stages a tail injection can slow down.
+const (
+	StageIdle = "idle"
+	StageBusy = "busy"
+	StageBoth = "both"
+)
+
+// Tail makes a fraction P of the tasks slow. A slow task has the
+// duration of each of its Stages multiplied by Factor and extended by Extra
+// plus a Pareto distributed delay with shape Alpha and scale Scale, when
+// Alpha is positive.
+type Tail struct {
+	P      float64       `json:"p"`
+	Stage  string        `json:"stage"`
+	Factor float64       `json:"factor"`
+	Extra  time.Duration `json:"extra"`
+	Alpha  float64       `json:"alpha,omitempty"`
+	Scale  time.Duration `json:"scale,omitempty"`
+}
+
+// TailInjection records what a fired Tail added to the task.
+type TailInjection struct {
+	Stage  string  `json:"stage"`
+	Factor float64 `json:"factor"`
+	Extra  int64   `json:"extra"`
+}
+
+// draw decides whether the task is slow and returns the injection to apply
+// to its stages, or nil.
+func (t Tail) draw(rng *rand.Rand) *TailInjection {
+	if t.P <= 0 || rng.Float64() >= t.P {
+		return nil
+	}
+	extra := float64(t.Extra)
+	if t.Alpha > 0 {
+		extra += ParetoDist{Alpha: t.Alpha, Scale: float64(t.Scale)}.Sample(rng)
+	}
+	return &TailInjection{Stage: t.Stage, Factor: t.Factor, Extra: saturate(extra)}
+}
+
+// stretch returns the targets of a stage of the given kind once slowed down
+// by the injection. Extra busy time on top of an iteration target extends
+// the stage through its wall time condition.
+func (inj *TailInjection) stretch(kind string, t Targets) Targets {
+	if inj == nil || (inj.Stage != kind && inj.Stage != StageBoth) {
+		return t
+	}
+	// Stretched targets saturate rather than wrap around for large factors
+	// and delays.
+	stretch := func(v int64) int64 { return saturate(float64(v) * inj.Factor) }
+	delay := func(d time.Duration) time.Duration {
+		return time.Duration(saturate(float64(stretch(int64(d))) + float64(inj.Extra)))
+	}
+	switch kind {
+	case StageIdle:
+		t.Duration = delay(t.Duration)
+	case StageBusy:
+		t.Duration = delay(t.Duration)
+		t.Iterations = stretch(t.Iterations)
+	}
+	return t
+}
@@ -0,0 +1,44 @@
+package workload
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestTailStretch ensures that a slow task stretches only the selected
+// stage, that Pareto delays never fall below the scale and that huge
+// delays and factors saturate.
+func TestTailStretch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tail := Tail{P: 1, Stage: StageBusy, Factor: 10, Alpha: 1.5, Scale: time.Millisecond}
+	for i := 0; i < 100; i++ {
+		slow := tail.draw(rng)
+		if slow == nil {
+			t.Fatal("tail with probability 1 did not fire")
+		}
+		idle := slow.stretch(StageIdle, Targets{Duration: 5})
+		busy := slow.stretch(StageBusy, Targets{Duration: 100})
+		if idle.Duration != 5 || busy.Iterations != 0 || busy.Duration != time.Duration(1000+slow.Extra) {
+			t.Fatalf("unexpected targets idle=%+v busy=%+v", idle, busy)
+		}
+		if slow.Extra < int64(time.Millisecond) {
+			t.Fatalf("Pareto delay below scale: %d", slow.Extra)
+		}
+	}
+	if slow := (Tail{Stage: StageIdle, Factor: 10}).draw(rng); slow != nil {
+		t.Fatal("tail with probability 0 fired")
+	}
+
+	// Huge delays and factors saturate rather than wrap around to negative
+	// targets.
+	huge := Tail{P: 1, Stage: StageBoth, Factor: 1e300, Alpha: 0.01, Scale: time.Second}
+	for i := 0; i < 100; i++ {
+		slow := huge.draw(rng)
+		busy := slow.stretch(StageBusy, Targets{Duration: time.Second, Iterations: 1000})
+		if slow.Extra < int64(time.Second) || busy.Duration != math.MaxInt64 || busy.Iterations != math.MaxInt64 {
+			t.Fatalf("unexpected injection %+v: %+v", slow, busy)
+		}
+	}
+}
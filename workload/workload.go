@@ -0,0 +1,306 @@
+// Package workload simulates the work of a serverless function: a task is a
+// sequence of idle, busy and call stages whose targets are sampled from
+// distributions, optionally stretched by a tail injection. It has no HTTP
+// server side and can be embedded in other handlers or benchmarks.
+package workload
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StageCall is the kind of a stage calling downstream functions, next to
+// StageIdle and StageBusy.
+const StageCall = "call"
+
+// tracer creates the spans of the stages and downstream calls, with the
+// global tracer provider and propagator.
+var tracer = otel.Tracer("simtask/workload")
+
+// Task is a simulated function invocation. Its stages run in order; Tail,
+// when set, may slow its idle or busy stages down. Rand draws the stage
+// targets and tail, and is seeded from the clock when nil.
+type Task struct {
+	Origin
+	Stages []Stage    `json:"stages"`
+	Tail   *Tail      `json:"tail,omitempty"`
+	Rand   *rand.Rand `json:"-"`
+}
+
+// Stage is a step of a task, of kind StageIdle, StageBusy or StageCall.
+//
+// An idle stage waits for Duration with Method. A busy stage runs Kernel
+// until its targets are met as combined by Policy: a wall time Duration,
+// Iterations or Work, which is ns of work on the reference hardware
+// converted to iterations, and a thread CPU time CPU. Unset targets are
+// ignored. A call stage makes the calls of Calls.
+//
+// Duration, Work and CPU are in ns, or carry a unit as in ParseDurationDist.
+// Max, when set, caps the targets the stage runs with after the tail
+// injection; its zero fields cap nothing.
+type Stage struct {
+	Kind       string     `json:"kind"`
+	Duration   Spec       `json:"duration,omitempty"`
+	Method     string     `json:"method,omitempty"`
+	Kernel     string     `json:"kernel,omitempty"`
+	Policy     string     `json:"policy,omitempty"`
+	Iterations Spec       `json:"iterations,omitempty"`
+	Work       Spec       `json:"work,omitempty"`
+	CPU        Spec       `json:"cpu,omitempty"`
+	Calls      *CallStage `json:"calls,omitempty"`
+	Max        *Targets   `json:"max,omitempty"`
+}
+
+// Targets are the sampled targets of a stage.
+type Targets struct {
+	Duration   time.Duration `json:"duration"`
+	Iterations int64         `json:"iterations"`
+	CPU        time.Duration `json:"cpu"`
+}
+
+// capped returns t with its targets lowered to the nonzero ones of max.
+func (t Targets) capped(max *Targets) Targets {
+	if max == nil {
+		return t
+	}
+	if max.Duration > 0 {
+		t.Duration = min(t.Duration, max.Duration)
+	}
+	if max.Iterations > 0 {
+		t.Iterations = min(t.Iterations, max.Iterations)
+	}
+	if max.CPU > 0 {
+		t.CPU = min(t.CPU, max.CPU)
+	}
+	return t
+}
+
+// Result is what a task did.
+type Result struct {
+	Start  time.Time      `json:"start"`
+	End    time.Time      `json:"end"`
+	Stages []StageResult  `json:"stages"`
+	Tail   *TailInjection `json:"tail,omitempty"`
+}
+
+// StageResult is what a stage did. Sampled are its targets as drawn and
+// Target the ones it ran with, after the tail injection. Iterations, CPU
+// and End, the condition that ended the stage, describe busy stages, and
+// Calls call stages.
+type StageResult struct {
+	Kind       string        `json:"kind"`
+	Start      time.Time     `json:"start"`
+	Duration   time.Duration `json:"duration"`
+	Sampled    Targets       `json:"sampled"`
+	Target     Targets       `json:"target"`
+	Method     string        `json:"method,omitempty"`
+	Kernel     string        `json:"kernel,omitempty"`
+	Iterations int64         `json:"iterations,omitempty"`
+	CPU        time.Duration `json:"cpu,omitempty"`
+	End        string        `json:"end,omitempty"`
+	Calls      []CallResult  `json:"calls,omitempty"`
+}
+
+// Stage returns the result of the first stage of the given kind, or a zero
+// result when the task has none.
+func (r Result) Stage(kind string) StageResult {
+	for _, s := range r.Stages {
+		if s.Kind == kind {
+			return s
+		}
+	}
+	return StageResult{}
+}
+
+// Validate checks the stages and tail of the task.
+func (t Task) Validate() error {
+	for i, s := range t.Stages {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("stage %d: %v", i, err)
+		}
+	}
+	if t.Tail != nil && t.Tail.P > 0 {
+		switch t.Tail.Stage {
+		case StageIdle, StageBusy, StageBoth:
+		default:
+			return fmt.Errorf("bad tail stage %q", t.Tail.Stage)
+		}
+	}
+	return nil
+}
+
+func (s Stage) validate() error {
+	for _, spec := range []struct {
+		name     string
+		spec     Spec
+		duration bool
+	}{{"duration", s.Duration, true}, {"iterations", s.Iterations, false}, {"work", s.Work, true}, {"cpu", s.CPU, true}} {
+		if spec.spec == "" {
+			continue
+		}
+		if _, err := spec.spec.parse(spec.duration); err != nil {
+			return fmt.Errorf("bad %s: %v", spec.name, err)
+		}
+	}
+	switch s.Kind {
+	case StageIdle:
+		switch s.Method {
+		case "", IdleSleep, IdleTimerSpin, IdleSpin:
+		case IdleNanosleep, IdleTimerfd:
+			if !IdleSyscalls {
+				return fmt.Errorf("idle method %q not supported on this platform", s.Method)
+			}
+		default:
+			return fmt.Errorf("bad idle method %q", s.Method)
+		}
+	case StageBusy:
+		if _, ok := kernels[s.Kernel]; !ok && s.Kernel != "" {
+			return fmt.Errorf("bad kernel %q", s.Kernel)
+		}
+		switch s.Policy {
+		case "", StopAll, StopAny:
+		case StopCPU:
+			if s.CPU == "" {
+				return fmt.Errorf("policy %q requires a cpu target", s.Policy)
+			}
+		default:
+			return fmt.Errorf("bad policy %q", s.Policy)
+		}
+		if s.Iterations != "" && s.Work != "" {
+			return fmt.Errorf("iterations and work are exclusive")
+		}
+		if s.CPU != "" && !CPUTimeSupported {
+			return fmt.Errorf("cpu target not supported on this platform")
+		}
+	case StageCall:
+		if s.Calls == nil {
+			return fmt.Errorf("call stage without calls")
+		}
+		return s.Calls.Validate()
+	default:
+		return fmt.Errorf("bad kind %q", s.Kind)
+	}
+	return nil
+}
+
+// sample returns the result of the stage before it runs, with its defaults
+// applied and its targets drawn from rng.
+func (s Stage) sample(rng *rand.Rand) (StageResult, error) {
+	r := StageResult{Kind: s.Kind}
+	d, err := s.Duration.sample(rng, true)
+	if err != nil {
+		return r, err
+	}
+	r.Sampled.Duration = time.Duration(d)
+	switch s.Kind {
+	case StageIdle:
+		r.Method = s.Method
+		if r.Method == "" {
+			r.Method = IdleSleep
+		}
+	case StageBusy:
+		r.Kernel = s.Kernel
+		if r.Kernel == "" {
+			r.Kernel = KernelLoop
+		}
+		if r.Sampled.Iterations, err = s.Iterations.sample(rng, false); err != nil {
+			return r, err
+		}
+		if s.Work != "" {
+			work, err := s.Work.sample(rng, true)
+			if err != nil {
+				return r, err
+			}
+			r.Sampled.Iterations = ReferenceIterations(r.Kernel, work)
+		}
+		cpu, err := s.CPU.sample(rng, true)
+		if err != nil {
+			return r, err
+		}
+		r.Sampled.CPU = time.Duration(cpu)
+	}
+	r.Target = r.Sampled
+	return r, nil
+}
+
+// Execute validates the task, draws its targets and runs its stages on the
+// calling goroutine, recording a span for each stage under the span of ctx.
+// The result of a stage that fails is returned with the error, and the
+// stages after it do not run. Once ctx is done, no further stage starts and
+// the error of ctx is returned: an idle stage ends early, see Idle, calls
+// are canceled, but a busy stage runs to its end.
+func Execute(ctx context.Context, task Task) (Result, error) {
+	if err := task.Validate(); err != nil {
+		return Result{}, err
+	}
+	rng := task.Rand
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	var res Result
+	for _, s := range task.Stages {
+		r, err := s.sample(rng)
+		if err != nil {
+			return Result{}, err
+		}
+		res.Stages = append(res.Stages, r)
+	}
+	if task.Tail != nil {
+		res.Tail = task.Tail.draw(rng)
+	}
+	for i := range res.Stages {
+		res.Stages[i].Target = res.Tail.stretch(res.Stages[i].Kind, res.Stages[i].Target).capped(task.Stages[i].Max)
+	}
+
+	res.Start = time.Now()
+	var err error
+	for i, s := range task.Stages {
+		if err = ctx.Err(); err != nil {
+			res.Stages = res.Stages[:i]
+			break
+		}
+		r := &res.Stages[i]
+		r.Start = time.Now()
+		switch s.Kind {
+		case StageIdle:
+			err = Idle(ctx, r.Method, r.Target.Duration)
+			r.Duration = time.Since(r.Start)
+			stageSpan(ctx, StageIdle, r.Start, r.Start.Add(r.Duration),
+				attribute.Int64("simtask.ts", int64(r.Target.Duration)), attribute.String("simtask.im", r.Method))
+		case StageBusy:
+			policy := s.Policy
+			if policy == "" {
+				policy = StopAll
+			}
+			busy := Busy{Policy: policy, It: r.Target.Iterations, Wall: r.Target.Duration, CPU: r.Target.CPU}.Run(r.Kernel)
+			r.Duration = time.Since(r.Start)
+			r.Iterations, r.CPU, r.End = busy.It, busy.CPU, busy.End
+			stageSpan(ctx, StageBusy, r.Start, r.Start.Add(r.Duration),
+				attribute.String("simtask.bk", r.Kernel), attribute.Int64("simtask.rit", r.Iterations), attribute.String("simtask.rbs", r.End))
+		case StageCall:
+			cctx, span := tracer.Start(ctx, StageCall, trace.WithAttributes(attribute.Int("simtask.calls", len(s.Calls.Calls))))
+			r.Calls = s.Calls.run(cctx, task.Origin)
+			span.End()
+			r.Duration = time.Since(r.Start)
+		}
+		if err != nil {
+			res.Stages = res.Stages[:i+1]
+			break
+		}
+	}
+	res.End = time.Now()
+	return res, err
+}
+
+// stageSpan records a stage that ran from start to end under the span of
+// ctx, so that measuring the stage does not include span overhead.
+func stageSpan(ctx context.Context, name string, start, end time.Time, attrs ...attribute.KeyValue) {
+	_, span := tracer.Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	span.End(trace.WithTimestamp(end))
+}
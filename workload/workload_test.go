@@ -0,0 +1,130 @@
+package workload
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestExecute ensures that a task runs its stages in order with targets
+// drawn from its seed, stretched by its tail.
+func TestExecute(t *testing.T) {
+	var task Task
+	err := json.Unmarshal([]byte(`{"stages": [
+		{"kind": "idle", "duration": 1000000},
+		{"kind": "busy", "iterations": "uniform:1000,2000", "policy": "any"}
+	], "tail": {"p": 1, "stage": "busy", "factor": 2}}`), &task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func() Result {
+		task.Rand = rand.New(rand.NewSource(7))
+		res, err := Execute(context.Background(), task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	res := run()
+	if len(res.Stages) != 2 || res.Stages[0].Kind != StageIdle || res.Stages[1].Kind != StageBusy {
+		t.Fatalf("unexpected stages: %+v", res.Stages)
+	}
+	idle, busy := res.Stage(StageIdle), res.Stage(StageBusy)
+	if idle.Method != IdleSleep || idle.Duration < time.Millisecond {
+		t.Errorf("unexpected idle stage: %+v", idle)
+	}
+	if busy.Kernel != KernelLoop || busy.Target.Iterations != 2*busy.Sampled.Iterations || busy.Iterations != busy.Target.Iterations {
+		t.Errorf("unexpected busy stage: %+v", busy)
+	}
+	if res.Tail == nil || res.End.Before(busy.Start) {
+		t.Errorf("unexpected task result: %+v", res)
+	}
+	if again := run().Stage(StageBusy); again.Sampled != busy.Sampled {
+		t.Errorf("seeded targets differ: %+v and %+v", busy.Sampled, again.Sampled)
+	}
+}
+
+// TestExecuteMax ensures that stage targets are capped after the tail
+// injection, leaving the sampled ones as drawn.
+func TestExecuteMax(t *testing.T) {
+	res, err := Execute(context.Background(), Task{
+		Stages: []Stage{
+			{Kind: StageIdle, Duration: "2ms", Max: &Targets{Duration: time.Millisecond}},
+			{Kind: StageBusy, Iterations: "1000", Max: &Targets{Iterations: 1500}},
+		},
+		Tail: &Tail{P: 1, Stage: StageBoth, Factor: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, busy := res.Stage(StageIdle), res.Stage(StageBusy)
+	if idle.Sampled.Duration != 2*time.Millisecond || idle.Target.Duration != time.Millisecond {
+		t.Errorf("unexpected idle targets: %+v", idle)
+	}
+	if busy.Sampled.Iterations != 1000 || busy.Target.Iterations != 1500 || busy.Iterations != 1500 {
+		t.Errorf("unexpected busy targets: %+v", busy)
+	}
+}
+
+// TestExecuteMaxHeavyTail ensures that the huge samples of heavy-tailed
+// distributions are capped rather than wrapped around to negative targets.
+func TestExecuteMaxHeavyTail(t *testing.T) {
+	task := Task{
+		Stages: []Stage{{Kind: StageBusy, Iterations: "pareto:0.05,1000", Max: &Targets{Iterations: 5000}}},
+		Rand:   rand.New(rand.NewSource(1)),
+	}
+	for i := 0; i < 200; i++ {
+		res, err := Execute(context.Background(), task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if busy := res.Stage(StageBusy); busy.Sampled.Iterations < 1000 || busy.Target.Iterations < 1000 || busy.Target.Iterations > 5000 {
+			t.Fatalf("unexpected busy targets: %+v", busy)
+		}
+	}
+}
+
+// TestExecuteInvalid ensures that invalid tasks are rejected before any
+// stage runs.
+func TestExecuteInvalid(t *testing.T) {
+	for _, s := range []Stage{
+		{Kind: "sleep"},
+		{Kind: StageIdle, Duration: "exp:-1"},
+		{Kind: StageIdle, Method: "poll"},
+		{Kind: StageBusy, Kernel: "matmul"},
+		{Kind: StageBusy, Iterations: "10", Work: "10"},
+		{Kind: StageBusy, Policy: StopCPU},
+		{Kind: StageCall},
+	} {
+		if _, err := Execute(context.Background(), Task{Stages: []Stage{s}}); err == nil {
+			t.Errorf("%+v: expected an error", s)
+		}
+	}
+}
+
+// TestExecuteCanceled ensures that a task stops at the stage running when
+// its context is done.
+func TestExecuteCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	res, err := Execute(ctx, Task{Stages: []Stage{{Kind: StageIdle, Duration: "1m"}, {Kind: StageBusy, Iterations: "1000"}}})
+	if err != context.DeadlineExceeded || len(res.Stages) != 1 || res.Stages[0].Duration > time.Second {
+		t.Errorf("unexpected result %+v: %v", res, err)
+	}
+}
+
+// TestCallStageTree ensures that calls handed on downstream, in stages or
+// in call parameters, count towards the size and depth of a stage.
+func TestCallStageTree(t *testing.T) {
+	s := CallStage{Calls: []Call{
+		{URL: "http://a/?call=http://b&call=" + url.QueryEscape("http://c/?call=http://d")},
+		{URL: "http://e/?call=http://x", Params: map[string]string{"call": "http://f"},
+			Next: &CallStage{Calls: []Call{{URL: "http://g"}}}},
+	}}
+	if calls, depth := s.Tree(); calls != 7 || depth != 3 {
+		t.Errorf("%d calls %d deep, want 7 calls 3 deep", calls, depth)
+	}
+}